@@ -0,0 +1,139 @@
+// Package archive streams files and directories as tar archives and safely extracts them.
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ContentType is the MIME type of archives written by Write.
+const ContentType = "application/x-tar"
+
+// Write streams the files and directories at paths into w as a tar archive.
+//
+// Each path is stored relative to its parent directory, so "build/out" is archived as "out/...".
+// Only regular files and directories are supported.
+func Write(w io.Writer, paths ...string) error {
+	tw := tar.NewWriter(w)
+
+	for _, root := range paths {
+		base := filepath.Dir(filepath.Clean(root))
+		err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			name, err := filepath.Rel(base, p)
+			if err != nil {
+				return err
+			}
+			return writeEntry(tw, p, filepath.ToSlash(name), info)
+		})
+		if err != nil {
+			return fmt.Errorf("archiving %v: %w", root, err)
+		}
+	}
+
+	return tw.Close()
+}
+
+func writeEntry(tw *tar.Writer, p, name string, info os.FileInfo) error {
+	if !info.IsDir() && !info.Mode().IsRegular() {
+		return fmt.Errorf("unsupported file type: %v", p)
+	}
+
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return nil
+	}
+
+	file, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(tw, file)
+	return err
+}
+
+// Extract unpacks the tar archive in r beneath dir.
+//
+// It rejects absolute paths, paths that would escape dir, and any entry
+// other than a regular file or directory.
+func Extract(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading archive: %w", err)
+		}
+
+		target, err := safeJoin(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := extractFile(tr, target, hdr); err != nil {
+				return fmt.Errorf("extracting %v: %w", hdr.Name, err)
+			}
+		default:
+			return fmt.Errorf("unsupported entry type %q: %v", hdr.Typeflag, hdr.Name)
+		}
+	}
+}
+
+func extractFile(r io.Reader, target string, hdr *tar.Header) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(hdr.Mode).Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+}
+
+// safeJoin joins an archive entry's name onto dir, refusing names that would land outside of it.
+func safeJoin(dir, name string) (string, error) {
+	if name == "" || path.IsAbs(name) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" || strings.Contains(name, `\`) {
+		return "", fmt.Errorf("unsafe path in archive: %q", name)
+	}
+	clean := path.Clean(name)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("unsafe path in archive: %q", name)
+	}
+	return filepath.Join(dir, filepath.FromSlash(clean)), nil
+}
@@ -0,0 +1,94 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestRoundTrip(t *testing.T) {
+	src := tempDir(t)
+	defer os.RemoveAll(src)
+	dst := tempDir(t)
+	defer os.RemoveAll(dst)
+
+	files := map[string]string{
+		"build/a.txt":        "file a",
+		"build/nested/b.txt": "file b",
+		"single.txt":         "single file",
+	}
+	for name, contents := range files {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, filepath.Join(src, "build"), filepath.Join(src, "single.txt")); err != nil {
+		t.Fatalf("writing: %v", err)
+	}
+	if err := Extract(&buf, dst); err != nil {
+		t.Fatalf("extracting: %v", err)
+	}
+
+	for name, want := range files {
+		p := filepath.Join(dst, filepath.FromSlash(name))
+		got, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Errorf("reading %v: %v", name, err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%v: got %q, want %q", name, got, want)
+		}
+
+		info, _ := os.Stat(p)
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("%v: got mode %v, want %v", name, perm, os.FileMode(0600))
+		}
+	}
+}
+
+func TestExtractUnsafe(t *testing.T) {
+	entries := map[string]*tar.Header{
+		"absolute path":  {Name: "/etc/passwd", Typeflag: tar.TypeReg},
+		"parent path":    {Name: "../escape.txt", Typeflag: tar.TypeReg},
+		"nested parent":  {Name: "ok/../../escape.txt", Typeflag: tar.TypeReg},
+		"backslash path": {Name: `..\escape.txt`, Typeflag: tar.TypeReg},
+		"symlink":        {Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+	}
+
+	for name, hdr := range entries {
+		t.Run(name, func(t *testing.T) {
+			dst := tempDir(t)
+			defer os.RemoveAll(dst)
+
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			tw.WriteHeader(hdr)
+			tw.Close()
+
+			if err := Extract(&buf, dst); err == nil {
+				t.Error("got nil error, want rejection")
+			}
+
+			written, _ := ioutil.ReadDir(dst)
+			if len(written) != 0 {
+				t.Errorf("got %v files written, want 0", len(written))
+			}
+		})
+	}
+}
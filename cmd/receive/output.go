@@ -0,0 +1,294 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/hunterloftis/storj/archive"
+	"github.com/hunterloftis/storj/relay"
+)
+
+// collision is what to do when a received file's name is already taken in the output directory.
+type collision int
+
+const (
+	renameCollisions    collision = iota // save under the first free numbered name, like "a-1.txt"
+	overwriteCollisions                  // replace the existing file
+	failCollisions                       // give up without touching the existing file
+)
+
+// collisionPolicy returns the policy chosen by -overwrite, -rename or -fail.
+func collisionPolicy() (collision, error) {
+	c, chosen := renameCollisions, 0
+	for set, policy := range map[*bool]collision{rename: renameCollisions, overwrite: overwriteCollisions, fail: failCollisions} {
+		if *set {
+			c, chosen = policy, chosen+1
+		}
+	}
+	if chosen > 1 {
+		return 0, errors.New("choose only one of -overwrite, -rename and -fail")
+	}
+	return c, nil
+}
+
+// target returns the path at which to save name in dir, as things stand.
+// The name may still be taken before anything is saved there, so place has the final say.
+func (c collision) target(dir, name string) (string, error) {
+	path := filepath.Join(dir, name)
+	if c == overwriteCollisions || !exists(path) {
+		return path, nil
+	}
+	if c == failCollisions {
+		return "", collided(path)
+	}
+	for i := 1; ; i++ {
+		if path = candidate(dir, name, i); !exists(path) {
+			return path, nil
+		}
+	}
+}
+
+// place moves from, a file or directory, into dir under name, returning the path it was saved at.
+// Unless overwriting, the path is first reserved by creating it exclusively, then replaced by a file
+// or filled with a directory's contents, so that a file of the same name that appeared while receiving
+// is never replaced.
+func (c collision) place(from, dir, name string, isDir bool) (string, error) {
+	if c == overwriteCollisions {
+		path := filepath.Join(dir, name)
+		return path, moveInto(from, path, isDir)
+	}
+	for i := 0; ; i++ {
+		path := candidate(dir, name, i)
+		err := reserve(path, isDir)
+		if os.IsExist(err) {
+			if c == failCollisions {
+				return "", collided(path)
+			}
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if err := fill(from, path, isDir); err != nil {
+			os.Remove(path)
+			return "", err
+		}
+		return path, nil
+	}
+}
+
+// fill replaces the file reserved at path with from, or moves from's contents into the directory reserved there.
+func fill(from, path string, isDir bool) error {
+	if !isDir {
+		return os.Rename(from, path)
+	}
+	info, err := os.Stat(from)
+	if err != nil {
+		return err
+	}
+	if err := moveInto(from, path, true); err != nil {
+		return err
+	}
+	return os.Chmod(path, info.Mode().Perm())
+}
+
+// candidate returns the i-th path to try for name in dir: the name itself, then numbered names like "a-1.txt".
+func candidate(dir, name string, i int) string {
+	if i == 0 {
+		return filepath.Join(dir, name)
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	return filepath.Join(dir, fmt.Sprintf("%v-%d%v", base, i, ext))
+}
+
+// reserve creates an empty file or directory at path, failing if anything is already there.
+func reserve(path string, isDir bool) error {
+	if isDir {
+		return os.Mkdir(path, 0700)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func collided(path string) error {
+	return fmt.Errorf("%v already exists (use -overwrite or -rename)", path)
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return !os.IsNotExist(err)
+}
+
+// reserved are the device names that Windows reserves, with or without an extension.
+var reserved = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true, "com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true, "lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// checkName refuses a name suggested by the sender that's empty, hidden (like ".bashrc"),
+// reserved, or that contains characters some filesystems can't hold.
+func checkName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("the sender didn't name the file")
+	case strings.HasPrefix(name, "."):
+		return fmt.Errorf("refusing hidden file name %q", name)
+	case strings.ContainsAny(name, `/\:*?"<>|`) || strings.IndexFunc(name, unicode.IsControl) >= 0:
+		return fmt.Errorf("refusing file name %q with unsafe characters", name)
+	case strings.HasSuffix(name, " ") || strings.HasSuffix(name, "."):
+		return fmt.Errorf("refusing file name %q that ends in a space or dot", name)
+	case reserved[strings.ToLower(strings.SplitN(name, ".", 2)[0])]:
+		return fmt.Errorf("refusing reserved file name %q", name)
+	}
+	return nil
+}
+
+// fileName returns the name under which to save a single file, from the one the sender suggested.
+func fileName(meta relay.Metadata) (string, error) {
+	_, name := filepath.Split(meta.Filename)
+	return name, checkName(name)
+}
+
+// receiveFile streams into a temporary file, which is only moved into place
+// once the stream's digest has been verified, and is removed if anything goes wrong.
+// It's created with the sender's permissions, which the process's umask applies to as for any new file,
+// and given the sender's modification time, if they were offered.
+func receiveFile(stream io.Reader, dir string, meta relay.Metadata, c collision) error {
+	name, err := fileName(meta)
+	if err != nil {
+		return err
+	}
+	if _, err := c.target(dir, name); err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0666)
+	if meta.Mode != 0 {
+		mode = meta.Mode.Perm() // never setuid, setgid or sticky
+	}
+	file, err := tempFile(dir, "."+name+".partial-", mode)
+	if err != nil {
+		return fmt.Errorf("writing to file in %v: %w", dir, err)
+	}
+	defer os.Remove(file.Name()) // a no-op once renamed
+
+	if _, err := io.Copy(file, stream); err != nil {
+		file.Close()
+		return fmt.Errorf("streaming file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("writing to file %v: %w", name, err)
+	}
+	if !meta.ModTime.IsZero() {
+		if err := os.Chtimes(file.Name(), meta.ModTime, meta.ModTime); err != nil {
+			return fmt.Errorf("writing to file %v: %w", name, err)
+		}
+	}
+
+	filename, err := c.place(file.Name(), dir, name, false)
+	if err != nil {
+		return err
+	}
+	if filename != filepath.Join(dir, name) {
+		fmt.Fprintf(os.Stderr, "saved as %v\n", filename)
+	}
+	return nil
+}
+
+// tempFile creates a new file in dir, named prefix followed by random characters.
+// Unlike ioutil.TempFile, it's created with mode, less the umask, like any other new file.
+func tempFile(dir, prefix string, mode os.FileMode) (*os.File, error) {
+	for i := 0; ; i++ {
+		random := make([]byte, 6)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		path := filepath.Join(dir, prefix+hex.EncodeToString(random))
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+		if os.IsExist(err) && i < 100 {
+			continue
+		}
+		return file, err
+	}
+}
+
+// receiveArchive extracts into a temporary directory, whose contents are only moved into place
+// once the stream's digest has been verified, and which is removed if anything goes wrong.
+func receiveArchive(stream io.Reader, dir string, c collision) error {
+	tmp, err := ioutil.TempDir(dir, ".partial-")
+	if err != nil {
+		return fmt.Errorf("creating directory in %v: %w", dir, err)
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := archive.Extract(stream, tmp); err != nil {
+		return fmt.Errorf("extracting archive: %w", err)
+	}
+
+	// read past the end of the archive to verify the digest
+	if _, err := io.Copy(ioutil.Discard, stream); err != nil {
+		return fmt.Errorf("streaming archive: %w", err)
+	}
+
+	return moveArchive(tmp, dir, c)
+}
+
+// moveArchive moves the files and directories at the top of an extracted archive into dir.
+// Their names are checked like a single file's, and the collision policy applies to each of them,
+// except that overwriting merges directories that already exist. Nothing is moved unless all of them can be,
+// as far as can be told beforehand.
+func moveArchive(src, dir string, c collision) error {
+	entries, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := checkName(entry.Name()); err != nil {
+			return err
+		}
+		if _, err := c.target(dir, entry.Name()); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range entries {
+		to, err := c.place(filepath.Join(src, entry.Name()), dir, entry.Name(), entry.IsDir())
+		if err != nil {
+			return err
+		}
+		if to != filepath.Join(dir, entry.Name()) {
+			fmt.Fprintf(os.Stderr, "saved %v as %v\n", entry.Name(), to)
+		}
+	}
+	return nil
+}
+
+// moveInto moves from to to, merging the contents of directories that already exist.
+func moveInto(from, to string, isDir bool) error {
+	if existing, err := os.Stat(to); err == nil && existing.IsDir() && isDir {
+		entries, err := ioutil.ReadDir(from)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := moveInto(filepath.Join(from, entry.Name()), filepath.Join(to, entry.Name()), entry.IsDir()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return os.Rename(from, to)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hunterloftis/storj/relay"
+)
+
+func TestFileName(t *testing.T) {
+	tests := []struct {
+		suggested string
+		want      string
+		refused   bool
+	}{
+		{"a.txt", "a.txt", false},
+		{"some/dir/a.txt", "a.txt", false},
+		{"../../a.txt", "a.txt", false},
+		{"", "", true},
+		{".", "", true},
+		{"..", "", true},
+		{"dir/..", "", true},
+		{".bashrc", "", true},
+		{"NUL.txt", "", true},
+		{"com1", "", true},
+		{"a.", "", true},
+		{"a ", "", true},
+		{"a:b.txt", "", true},
+		{"a\x1b[2J.txt", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.suggested, func(t *testing.T) {
+			name, err := fileName(relay.Metadata{Filename: test.suggested})
+			if test.refused {
+				if err == nil {
+					t.Errorf("got %q, want an error", name)
+				}
+				return
+			}
+			if err != nil || name != test.want {
+				t.Errorf("got %q and %v, want %q", name, err, test.want)
+			}
+		})
+	}
+}
+
+func TestTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string // ending in "/" for directories
+		policy   collision
+		want     string
+		err      bool
+	}{
+		{"a.txt", nil, renameCollisions, "a.txt", false},
+		{"a.txt", []string{"a.txt"}, renameCollisions, "a-1.txt", false},
+		{"a.txt", []string{"a.txt", "a-1.txt"}, renameCollisions, "a-2.txt", false},
+		{"a.txt", []string{"a.txt/"}, renameCollisions, "a-1.txt", false},
+		{"docs", []string{"docs/"}, renameCollisions, "docs-1", false},
+		{"a.txt", []string{"a.txt"}, overwriteCollisions, "a.txt", false},
+		{"docs", []string{"docs/"}, overwriteCollisions, "docs", false},
+		{"a.txt", nil, failCollisions, "a.txt", false},
+		{"a.txt", []string{"a.txt"}, failCollisions, "", true},
+		{"docs", []string{"docs/"}, failCollisions, "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name+" "+strings.Join(test.existing, " "), func(t *testing.T) {
+			dir := tempDir(t, test.existing...)
+			defer os.RemoveAll(dir)
+
+			got, err := test.policy.target(dir, test.name)
+			if test.err {
+				if err == nil {
+					t.Errorf("got %q, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != filepath.Join(dir, test.want) {
+				t.Errorf("got %q and %v, want %q", got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestReceiveFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy collision
+		want   map[string]string
+		err    bool
+	}{
+		{"rename", renameCollisions, map[string]string{"a.txt": "old", "a-1.txt": "new"}, false},
+		{"overwrite", overwriteCollisions, map[string]string{"a.txt": "new"}, false},
+		{"fail", failCollisions, map[string]string{"a.txt": "old"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := tempDir(t, "a.txt")
+			defer os.RemoveAll(dir)
+
+			err := receiveFile(strings.NewReader("new"), dir, relay.Metadata{Filename: "a.txt"}, test.policy)
+			if test.err != (err != nil) {
+				t.Errorf("got %v", err)
+			}
+			if got := contents(t, dir); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+
+		// a file of the same name appears while receiving, after the name was checked
+		t.Run(test.name+" while receiving", func(t *testing.T) {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+
+			stream := &hookReader{Reader: strings.NewReader("new"), hook: func() {
+				ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("old"), 0644)
+			}}
+			err := receiveFile(stream, dir, relay.Metadata{Filename: "a.txt"}, test.policy)
+			if test.err != (err != nil) {
+				t.Errorf("got %v", err)
+			}
+			if got := contents(t, dir); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReceiveFileMode(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// learn the umask from a file created with every permission
+	probe, err := os.OpenFile(filepath.Join(dir, "probe"), os.O_CREATE|os.O_WRONLY, 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe.Close()
+	info, _ := os.Stat(probe.Name())
+	umask := 0777 &^ info.Mode().Perm()
+	os.Remove(probe.Name())
+
+	tests := []struct {
+		name string
+		mode os.FileMode
+		want os.FileMode
+	}{
+		{"default.txt", 0, 0666 &^ umask},
+		{"script.sh", 0775, 0775 &^ umask},
+		{"private.txt", 0600, 0600},
+		{"setuid", 0755 | os.ModeSetuid | os.ModeSticky, 0755 &^ umask},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			meta := relay.Metadata{Filename: test.name, Mode: test.mode}
+			if err := receiveFile(strings.NewReader("new"), dir, meta, failCollisions); err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(filepath.Join(dir, test.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := info.Mode(); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMoveArchive(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy collision
+		want   map[string]string
+		err    bool
+	}{
+		{"rename", renameCollisions, map[string]string{"docs/a.txt": "old", "docs-1/a.txt": "new", "docs-1/b.txt": "new"}, false},
+		{"overwrite", overwriteCollisions, map[string]string{"docs/a.txt": "new", "docs/b.txt": "new"}, false},
+		{"fail", failCollisions, map[string]string{"docs/a.txt": "old"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src, dir := tempDir(t, "docs/a.txt", "docs/b.txt"), tempDir(t, "docs/a.txt")
+			defer os.RemoveAll(src)
+			defer os.RemoveAll(dir)
+			for _, name := range []string{"docs/a.txt", "docs/b.txt"} {
+				ioutil.WriteFile(filepath.Join(src, name), []byte("new"), 0644)
+			}
+
+			err := moveArchive(src, dir, test.policy)
+			if test.err != (err != nil) {
+				t.Errorf("got %v", err)
+			}
+			if got := contents(t, dir); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	t.Run("refuses hidden names", func(t *testing.T) {
+		src, dir := tempDir(t, ".bashrc"), tempDir(t)
+		defer os.RemoveAll(src)
+		defer os.RemoveAll(dir)
+
+		if err := moveArchive(src, dir, overwriteCollisions); err == nil {
+			t.Error("moved a hidden file")
+		}
+		if got := contents(t, dir); len(got) != 0 {
+			t.Errorf("got %v", got)
+		}
+	})
+}
+
+// tempDir creates a temporary directory holding the given paths, each a file containing "old",
+// or a directory if it ends in "/".
+func tempDir(t *testing.T, paths ...string) string {
+	dir, err := ioutil.TempDir("", "storj-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		full := filepath.Join(dir, filepath.FromSlash(path))
+		if strings.HasSuffix(path, "/") {
+			err = os.MkdirAll(full, 0755)
+		} else if err = os.MkdirAll(filepath.Dir(full), 0755); err == nil {
+			err = ioutil.WriteFile(full, []byte("old"), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// contents maps the paths of the files beneath dir to their contents.
+func contents(t *testing.T, dir string) map[string]string {
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(b)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// hookReader calls hook once it has been read to the end.
+type hookReader struct {
+	io.Reader
+	hook func()
+}
+
+func (r *hookReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF && r.hook != nil {
+		r.hook()
+		r.hook = nil
+	}
+	return n, err
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"unicode"
+
+	"github.com/hunterloftis/storj/archive"
+	"github.com/hunterloftis/storj/progress"
+	"github.com/hunterloftis/storj/relay"
+)
+
+// stdin is shared by every prompt, so that buffered input isn't lost between them.
+var stdin = bufio.NewReader(os.Stdin)
+
+// readSecret prompts for a code, completing its words when tab is pressed if stdin is a terminal.
+func readSecret() (string, error) {
+	fmt.Fprint(os.Stderr, "code: ")
+	if !interactive() {
+		return readLine()
+	}
+	restore, err := rawMode(os.Stdin)
+	if err != nil {
+		return readLine() // no stty, so no completion
+	}
+	defer restore()
+
+	// don't leave the terminal without echo if the user gives up
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-interrupts:
+			restore()
+			fmt.Fprintln(os.Stderr)
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+
+	return editLine(stdin, os.Stderr, "code: ")
+}
+
+// editLine reads a line of input from a terminal in raw mode, echoing it to out.
+// Tab completes the word being typed, or lists the candidates when pressed twice.
+func editLine(in io.ByteReader, out io.Writer, prompt string) (string, error) {
+	var line []byte
+	tabbed := false
+	for {
+		c, err := in.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case c == '\r' || c == '\n':
+			fmt.Fprintln(out)
+			return string(line), nil
+		case c == 4 && len(line) == 0: // Ctrl-D
+			fmt.Fprintln(out)
+			return "", io.EOF
+		case c == 127 || c == '\b':
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				fmt.Fprint(out, "\b \b")
+			}
+		case c == '\t':
+			completion, candidates := complete(string(line))
+			line = append(line, completion...)
+			fmt.Fprint(out, completion)
+			if completion == "" && tabbed && len(candidates) > 1 {
+				fmt.Fprintf(out, "\r\n%v\r\n%v%s", strings.Join(candidates, "  "), prompt, line)
+			}
+		case c >= ' ' && c < 127:
+			line = append(line, c)
+			fmt.Fprintf(out, "%c", c)
+		}
+		tabbed = c == '\t'
+	}
+}
+
+// complete returns the text that completes the last word of line, and the words it might be.
+// A word completed in full is followed by a hyphen if there's another word to type.
+func complete(line string) (completion string, candidates []string) {
+	candidates = passwords.Complete(line)
+	if len(candidates) == 0 {
+		return "", nil
+	}
+	typed := line[strings.LastIndex(line, "-")+1:]
+
+	prefix := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	completion = prefix[len(typed):]
+	if len(candidates) == 1 && len(passwords.Complete(line+completion+"-")) > 0 {
+		completion += "-"
+	}
+	return completion, candidates
+}
+
+// acceptOffer shows what the sender is offering and asks whether to receive it.
+// Declining, or closing stdin, turns the offer down.
+func acceptOffer(meta relay.Metadata) bool {
+	describeOffer(meta)
+	ok, err := confirm("receive it?")
+	return err == nil && ok
+}
+
+// describeOffer prints what's on offer, and any note from the sender.
+func describeOffer(meta relay.Metadata) {
+	kind := "file"
+	if meta.ContentType == archive.ContentType {
+		kind = "archive"
+	}
+	size := "size unknown"
+	if meta.Size >= 0 {
+		size = progress.Bytes(meta.Size)
+	}
+	fmt.Fprintf(os.Stderr, "offered %v %q (%v)\n", kind, meta.Filename, size)
+	if meta.Note != "" {
+		for _, line := range strings.Split(printable(meta.Note), "\n") {
+			fmt.Fprintf(os.Stderr, "  %v\n", line)
+		}
+	}
+}
+
+// printable strips control characters other than line breaks from text the sender wrote,
+// so that it can't rewrite the terminal.
+func printable(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r != '\n' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// confirm asks a yes or no question, defaulting to yes.
+func confirm(question string) (bool, error) {
+	fmt.Fprintf(os.Stderr, "%v [Y/n] ", question)
+	answer, err := readLine()
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(answer) {
+	case "", "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// readLine reads a line from stdin.
+func readLine() (string, error) {
+	line, err := stdin.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// rawMode makes f, a terminal, deliver each key as it's pressed without echoing it,
+// returning a func that restores its previous state.
+func rawMode(f *os.File) (restore func(), err error) {
+	state, err := stty(f, "-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty(f, "-icanon", "-echo", "min", "1"); err != nil {
+		return nil, err
+	}
+	return func() {
+		stty(f, strings.TrimSpace(state))
+	}, nil
+}
+
+// interactive reports whether stdin is a terminal someone can answer questions at.
+// Other character devices, like the /dev/null that services and cron jobs get, aren't.
+func interactive() bool {
+	if !progress.IsTerminal(os.Stdin) {
+		return false
+	}
+	_, err := stty(os.Stdin, "-g")
+	return err == nil
+}
+
+func stty(f *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = f
+	out, err := cmd.Output()
+	return string(out), err
+}
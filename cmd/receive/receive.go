@@ -1,16 +1,35 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"strings"
+	"syscall"
 
+	"github.com/hunterloftis/storj/archive"
+	"github.com/hunterloftis/storj/progress"
 	"github.com/hunterloftis/storj/relay"
 )
 
+var (
+	caFile      = flag.String("ca", "", "connect over HTTPS, trusting the PEM CA certificates in this file")
+	pin         = flag.String("pin", "", "connect over HTTPS, trusting only the certificate with this SHA-256 fingerprint")
+	printDigest = flag.Bool("digest", false, "print the received file's SHA-256 to stderr")
+	yes         = flag.Bool("yes", false, "accept the offer without asking")
+	rename      = flag.Bool("rename", false, "if a file already exists, save the received one under a numbered name like a-1.txt (the default)")
+	overwrite   = flag.Bool("overwrite", false, "if a file already exists, replace it")
+	fail        = flag.Bool("fail", false, "if a file already exists, fail without receiving anything")
+	language    = flag.String("language", "en", "correct and complete the code's words from the built-in list for this language ("+strings.Join(relay.Languages(), ", ")+")")
+)
+
+// passwords are the words that codes are corrected and completed against, for -language.
+var passwords relay.PasswordWords
+
 func main() {
 	if err := receive(); err != nil {
 		log.Fatalf("error: %v", err)
@@ -18,30 +37,177 @@ func main() {
 }
 
 func receive() error {
-	if len(os.Args) < 4 {
+	flag.Parse()
+
+	words, ok := relay.Passwords(*language)
+	if !ok {
+		return fmt.Errorf("no wordlist for language %q (have %v)", *language, strings.Join(relay.Languages(), ", "))
+	}
+	passwords = words
+
+	var addr, secret, dir string
+	switch flag.NArg() {
+	case 3:
+		addr, secret, dir = flag.Arg(0), flag.Arg(1), flag.Arg(2)
+	case 2:
+		addr, dir = flag.Arg(0), flag.Arg(1)
+		typed, err := readSecret()
+		if err != nil {
+			return fmt.Errorf("reading code: %w", err)
+		}
+		secret = typed
+	default:
 		return errors.New("insufficient arguments")
 	}
+	secret, err := checkSecret(secret)
+	if err != nil {
+		return err
+	}
+	policy, err := collisionPolicy()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating output directory: %w", err)
+	}
 
-	addr := os.Args[1]
-	secret := os.Args[2]
-	dir := os.Args[3]
+	clientOpts, err := clientOptions()
+	if err != nil {
+		return err
+	}
 
-	client := relay.NewClient(addr)
-	suggestedName, stream, err := client.Receive(secret)
+	// without a terminal to ask on, as when scripted, accept the offer but still show what it was
+	ask := interactive()
+
+	// turn down offers that can't be saved before anything is streamed
+	var refused error
+	opts := []relay.ReceiveOption{relay.WithConfirm(func(meta relay.Metadata) bool {
+		if meta.ContentType != archive.ContentType {
+			name, err := fileName(meta)
+			if err == nil {
+				_, err = policy.target(dir, name)
+			}
+			if refused = err; err != nil {
+				return false
+			}
+		}
+		switch {
+		case *yes:
+			return true
+		case !ask:
+			describeOffer(meta)
+			return true
+		}
+		return acceptOffer(meta)
+	})}
+	var bar *progress.Bar
+	if progress.IsTerminal(os.Stderr) {
+		bar = progress.NewBar(os.Stderr)
+		opts = append(opts, relay.WithReceiveProgress(bar.Update))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := relay.NewClient(addr, clientOpts...)
+	meta, stream, err := client.ReceiveContext(ctx, secret, opts...)
+	if errors.Is(err, relay.ErrDeclined) {
+		if refused != nil {
+			return refused
+		}
+		return err
+	}
 	if err != nil {
 		return fmt.Errorf("opening receive stream: %w", err)
 	}
+	defer stream.Close()
+
+	// on Ctrl-C, fail the stream so that partial files are cleaned up
+	stop := cancelOnInterrupt(cancel)
+	defer stop()
 
-	_, name := filepath.Split(suggestedName)
-	filename := filepath.Join(dir, name)
-	file, err := os.Create(filename)
+	if meta.ContentType == archive.ContentType {
+		err = receiveArchive(stream, dir, policy)
+	} else {
+		err = receiveFile(stream, dir, meta, policy)
+	}
+	if bar != nil {
+		bar.Finish()
+	}
 	if err != nil {
-		return fmt.Errorf("writing to file %v: %w", filename, err)
+		return err
 	}
-	defer file.Close()
 
-	if _, err := io.Copy(file, stream); err != nil {
-		return fmt.Errorf("streaming file: %w", err)
+	if *printDigest {
+		fmt.Fprintf(os.Stderr, "sha256 %x\n", stream.(relay.Digester).Digest())
 	}
 	return nil
 }
+
+// checkSecret corrects misspelled words in secret, asking first when there's someone at the terminal to ask.
+// A code spelled exactly from another language's words is left alone, since its sender chose that language.
+func checkSecret(secret string) (string, error) {
+	words := passwords
+	if _, changed := words.Correct(secret); changed {
+		for _, lang := range relay.Languages() {
+			other, _ := relay.Passwords(lang)
+			if _, changed := other.Correct(secret); !changed {
+				words = other
+				break
+			}
+		}
+	}
+	corrected, changed := words.Correct(secret)
+	if !changed {
+		return corrected, nil
+	}
+	if !interactive() {
+		return "", fmt.Errorf("unknown words in code %q (did you mean %v?)", secret, corrected)
+	}
+	ok, err := confirm(fmt.Sprintf("did you mean %v?", corrected))
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return secret, nil
+	}
+	return corrected, nil
+}
+
+// cancelOnInterrupt calls cancel on Ctrl-C or SIGTERM, until the returned func is called.
+func cancelOnInterrupt(cancel context.CancelFunc) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+func clientOptions() ([]relay.ClientOption, error) {
+	switch {
+	case *pin != "":
+		config, err := relay.PinnedTLSConfig(*pin)
+		if err != nil {
+			return nil, err
+		}
+		return []relay.ClientOption{relay.WithTLSConfig(config)}, nil
+
+	case *caFile != "":
+		config, err := relay.CAFileTLSConfig(*caFile)
+		if err != nil {
+			return nil, err
+		}
+		return []relay.ClientOption{relay.WithTLSConfig(config)}, nil
+	}
+
+	return nil, nil
+}
@@ -1,16 +1,57 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
-	"math/rand"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/hunterloftis/storj/relay"
 )
 
+var (
+	certFile   = flag.String("cert", "", "serve HTTPS with the PEM certificate in this file (requires -key)")
+	keyFile    = flag.String("key", "", "PEM private key for -cert")
+	selfSigned = flag.Bool("self-signed", false, "serve HTTPS with a generated self-signed certificate")
+	certDir    = flag.String("self-signed-dir", "", "keep the -self-signed certificate and key in this directory, to reuse on later starts (default the user config directory)")
+	storeDir   = flag.String("store", "", "share offers with other relays through this directory (requires -self)")
+	self       = flag.String("self", "", "URL at which other relays sharing -store reach this one")
+	clusterKey = flag.String("cluster-key-file", "", "file holding the key that relays sharing -store use to trust each other")
+	logFormat  = flag.String("log-format", "logfmt", "log as logfmt or json")
+	logLevel   = flag.String("log-level", "info", "log events at or above debug, info, warn or error")
+	adminAddr  = flag.String("admin", "", "serve the admin API on this private address (requires -admin-key-file)")
+	adminKey   = flag.String("admin-key-file", "", "file holding the bearer key that authorizes admin API requests")
+	proxies    = flag.String("trusted-proxies", "", "comma-separated CIDRs of proxies whose Forwarded and X-Forwarded-For headers identify clients")
+	proxyProto = flag.Bool("proxy-protocol", false, "expect an HAProxy PROXY protocol header on every connection")
+	lifetime   = flag.Duration("offer-timeout", 10*time.Minute, "how long offers wait for a receiver, unless their sender chooses")
+	maxLife    = flag.Duration("max-offer-timeout", time.Hour, "the longest a sender may choose for its offer to wait")
+	idle       = flag.Duration("idle-timeout", time.Minute, "abort transfers that relay nothing for this long (0 to disable)")
+	minRate    = flag.Int64("min-rate", 0, "abort transfers slower than this many bytes per second, averaged over 30s (0 to disable)")
+	ipOffers   = flag.Int("max-offers-per-ip", 10, "how many offers each client IP may hold at once (0 for no limit)")
+	transfers  = flag.Int("max-transfers", 100, "how many transfers may be in progress at once (0 for no limit)")
+	maxSize    = flag.Int64("max-size", 0, "the largest file, in bytes, that may be sent (0 for no limit)")
+	rate       = flag.Int64("transfer-rate", 0, "cap each transfer at this many bytes per second (0 for no limit)")
+	totalRate  = flag.Int64("total-rate", 0, "cap all transfers combined at this many bytes per second (0 for no limit)")
+	numbers    = flag.Int("numbers", 999, "begin each secret with a number up to this (0 for only words)")
+	wordCount  = flag.Int("words", 0, "number of words following the number in each secret")
+	language   = flag.String("language", "en", "draw secret words from the built-in list for this language ("+strings.Join(relay.Languages(), ", ")+")")
+	wordlist   = flag.String("wordlist", "", "file of words, one per line, from which to draw secrets instead of a built-in list")
+	homophones = flag.String("homophones", "", "file of words that sound alike, one group per line; the wordlist may hold only one word of each group")
+	drain      = flag.Duration("drain", 10*time.Minute, "on SIGTERM or interrupt, how long to let transfers finish before stopping")
+)
+
 func main() {
 	if err := start(); err != nil {
 		log.Fatalf("error: %v", err)
@@ -18,15 +59,275 @@ func main() {
 }
 
 func start() error {
-	if len(os.Args) < 2 {
+	flag.Parse()
+	if flag.NArg() < 1 {
 		return errors.New("insufficient arguments")
 	}
 
-	addr := os.Args[1]
+	addr := flag.Arg(0)
+	if *lifetime <= 0 || *maxLife <= 0 {
+		return errors.New("-offer-timeout and -max-offer-timeout must be positive")
+	}
+
+	logger, err := logger()
+	if err != nil {
+		return err
+	}
+	stalls := relay.DefaultStallLimits
+	stalls.Idle, stalls.MinRate = *idle, *minRate
+	opts := []relay.HandlerOption{
+		relay.WithLogger(logger),
+		relay.WithOfferLifetime(*lifetime, *maxLife),
+		relay.WithStallLimits(stalls),
+		relay.WithQuotas(relay.Quotas{
+			OffersPerIP:  *ipOffers,
+			Transfers:    *transfers,
+			MaxSize:      *maxSize,
+			TransferRate: *rate,
+			TotalRate:    *totalRate,
+		}),
+	}
+	if *proxies != "" {
+		networks, err := trustedProxies()
+		if err != nil {
+			return err
+		}
+		opts = append(opts, relay.WithTrustedProxies(networks...))
+	}
+	if *storeDir != "" {
+		cluster, err := cluster()
+		if err != nil {
+			return err
+		}
+		opts = append(opts, relay.WithCluster(cluster))
+	}
+
+	secrets, bits, err := secrets()
+	if err != nil {
+		return err
+	}
+	source := *language
+	if *wordlist != "" {
+		source = *wordlist
+	}
+	logger.Log(relay.LevelInfo, "generating secrets", "wordlist", source, "numbers", *numbers, "words", *wordCount, "entropy_bits", fmt.Sprintf("%.1f", bits))
+	handler := relay.NewHandler(secrets, os.Stdout, opts...)
+
+	cert, ok, err := certificate(addr)
+	if err != nil {
+		return err
+	}
+
+	servers := []*http.Server{{Addr: addr, Handler: handler}}
+	if *adminAddr != "" {
+		admin, err := adminServer(handler)
+		if err != nil {
+			return err
+		}
+		servers = append(servers, admin)
+	}
 
-	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
-	secrets := relay.NewSecrets(rng)
-	handler := relay.NewHandler(secrets, os.Stdout)
+	if ok {
+		// clients can pin this rather than trusting a CA
+		fmt.Printf("certificate fingerprint: %v\n", relay.CertificateFingerprint(cert.Certificate[0]))
+		for _, server := range servers {
+			server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		}
+	}
+
+	listeners := make([]net.Listener, len(servers))
+	for i, server := range servers {
+		l, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			return err
+		}
+		defer l.Close()
+		listeners[i] = l
+	}
+	if *proxyProto {
+		// only the relay sits behind the proxy, not the admin API
+		listeners[0] = relay.NewProxyListener(listeners[0])
+	}
+
+	errs := make(chan error, len(servers))
+	for i, server := range servers {
+		go func(server *http.Server, l net.Listener) {
+			if ok {
+				errs <- server.ServeTLS(l, "", "")
+			} else {
+				errs <- server.Serve(l)
+			}
+		}(server, listeners[i])
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		shutdown(handler, logger, servers)
+		close(stopped)
+	}()
+
+	if err := <-errs; err != http.ErrServerClosed {
+		return err
+	}
+	<-stopped
+	return nil
+}
+
+// trustedProxies parses the networks in -trusted-proxies.
+func trustedProxies() ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range strings.Split(*proxies, ",") {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("parsing -trusted-proxies: %w", err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// adminServer serves the admin API, authorized by the key in -admin-key-file.
+func adminServer(handler *relay.Handler) (*http.Server, error) {
+	if *adminKey == "" {
+		return nil, errors.New("-admin requires -admin-key-file")
+	}
+	key, err := ioutil.ReadFile(*adminKey)
+	if err != nil {
+		return nil, fmt.Errorf("reading admin key: %w", err)
+	}
+	if len(strings.TrimSpace(string(key))) == 0 {
+		return nil, errors.New("admin key is empty")
+	}
+	return &http.Server{Addr: *adminAddr, Handler: handler.Admin(strings.TrimSpace(string(key)))}, nil
+}
+
+// shutdown waits for SIGTERM or an interrupt, then drains the relay's transfers and stops the servers.
+func shutdown(handler *relay.Handler, logger relay.Logger, servers []*http.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	sig := <-signals
+	logger.Log(relay.LevelInfo, "shutting down", "signal", sig, "drain", *drain)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *drain)
+	defer cancel()
+	if err := handler.Shutdown(ctx); err != nil {
+		logger.Log(relay.LevelWarn, "transfers cancelled", "error", err)
+	}
+
+	// the offers are gone, so any remaining requests finish promptly
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Log(relay.LevelError, "stopping server failed", "addr", server.Addr, "error", err)
+		}
+	}
+}
+
+// secrets generates secrets from the configured wordlist, returning their entropy in bits.
+func secrets() (relay.Secrets, float64, error) {
+	list, ok := relay.Wordlist(*language)
+	if !ok {
+		return relay.Secrets{}, 0, fmt.Errorf("no wordlist for language %q (have %v)", *language, strings.Join(relay.Languages(), ", "))
+	}
+	if *wordlist != "" {
+		err := readFile(*wordlist, func(r io.Reader) (err error) {
+			list, err = relay.ReadWordlist(r)
+			return err
+		})
+		if err != nil {
+			return relay.Secrets{}, 0, fmt.Errorf("reading wordlist: %w", err)
+		}
+	}
+	var groups [][]string
+	if *homophones != "" {
+		err := readFile(*homophones, func(r io.Reader) (err error) {
+			groups, err = relay.ReadHomophones(r)
+			return err
+		})
+		if err != nil {
+			return relay.Secrets{}, 0, fmt.Errorf("reading homophones: %w", err)
+		}
+	}
+	if err := relay.ValidateWordlist(list, groups); err != nil {
+		return relay.Secrets{}, 0, fmt.Errorf("invalid wordlist: %w", err)
+	}
+
+	secrets, bits := relay.NewSecrets(relay.WithNumbers(*numbers), relay.WithWordCount(*wordCount), relay.WithWordlist(list))
+	return secrets, bits, nil
+}
+
+// readFile opens the file at path for read.
+func readFile(path string, read func(io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return read(f)
+}
+
+// logger logs to stdout in the configured format.
+func logger() (relay.Logger, error) {
+	level, err := relay.ParseLevel(*logLevel)
+	if err != nil {
+		return nil, err
+	}
+	switch *logFormat {
+	case "logfmt":
+		return relay.NewLogfmtLogger(os.Stdout, level), nil
+	case "json":
+		return relay.NewJSONLogger(os.Stdout, level), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", *logFormat)
+}
+
+// cluster configures the relay to share offers with others through a directory.
+func cluster() (relay.Cluster, error) {
+	if *self == "" || *clusterKey == "" {
+		return relay.Cluster{}, errors.New("-store requires -self and -cluster-key-file")
+	}
+	store, err := relay.NewFileStore(*storeDir, relay.WithMaxOfferTimeout(*maxLife))
+	if err != nil {
+		return relay.Cluster{}, err
+	}
+	key, err := ioutil.ReadFile(*clusterKey)
+	if err != nil {
+		return relay.Cluster{}, fmt.Errorf("reading cluster key: %w", err)
+	}
+	return relay.Cluster{
+		Store: store,
+		Self:  strings.TrimSuffix(*self, "/"),
+		Key:   strings.TrimSpace(string(key)),
+	}, nil
+}
+
+// certificate loads or generates a certificate, if the relay should serve HTTPS.
+func certificate(addr string) (cert tls.Certificate, ok bool, err error) {
+	switch {
+	case *certFile != "" || *keyFile != "":
+		cert, err = tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			return cert, false, fmt.Errorf("loading certificate: %w", err)
+		}
+		return cert, true, nil
+
+	case *selfSigned:
+		dir := *certDir
+		if dir == "" {
+			config, err := os.UserConfigDir()
+			if err != nil {
+				return cert, false, fmt.Errorf("finding a directory for the certificate (set -self-signed-dir): %w", err)
+			}
+			dir = filepath.Join(config, "storj-relay")
+		}
+		host, _, _ := net.SplitHostPort(addr)
+		cert, err = relay.PersistentSelfSignedCertificate(dir, host, "localhost")
+		if err != nil {
+			return cert, false, fmt.Errorf("generating certificate: %w", err)
+		}
+		return cert, true, nil
+	}
 
-	return http.ListenAndServe(addr, handler)
+	return cert, false, nil
 }
@@ -1,15 +1,34 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/hunterloftis/storj/archive"
+	"github.com/hunterloftis/storj/progress"
 	"github.com/hunterloftis/storj/relay"
 )
 
+var (
+	caFile      = flag.String("ca", "", "connect over HTTPS, trusting the PEM CA certificates in this file")
+	pin         = flag.String("pin", "", "connect over HTTPS, trusting only the certificate with this SHA-256 fingerprint")
+	printDigest = flag.Bool("digest", false, "print the sent file's SHA-256 to stderr")
+	note        = flag.String("note", "", "a message for the receiver to see before accepting the file")
+	expires     = flag.Duration("expires", 0, "ask the relay to keep the offer this long, rather than its default")
+	language    = flag.String("language", "en", "draw the code's password words from the built-in list for this language ("+strings.Join(relay.Languages(), ", ")+")")
+)
+
 func main() {
 	if err := send(); err != nil {
 		log.Fatalf("error: %v", err)
@@ -17,26 +36,166 @@ func main() {
 }
 
 func send() error {
-	if len(os.Args) < 3 {
+	flag.Parse()
+	if flag.NArg() < 2 {
 		return errors.New("insufficient arguments")
 	}
 
-	addr := os.Args[1]
-	filename := os.Args[2]
+	addr := flag.Arg(0)
+	paths := flag.Args()[1:]
 
-	_, name := filepath.Split(filename)
-	file, err := os.Open(filename)
+	name, file, opts, err := open(paths)
 	if err != nil {
-		return fmt.Errorf("opening file %v: %w", filename, err)
+		return err
 	}
 	defer file.Close()
 
-	client := relay.NewClient(addr)
-	secret, send, err := client.Offer(name, file)
+	var sum []byte
+	if *printDigest {
+		opts = append(opts, relay.WithDigestFunc(func(s []byte) {
+			sum = s
+		}))
+	}
+
+	if *note != "" {
+		opts = append(opts, relay.WithNote(*note))
+	}
+	if *expires > 0 {
+		opts = append(opts, relay.WithExpiry(*expires))
+	}
+	var expiry time.Time
+	opts = append(opts, relay.WithExpiresFunc(func(t time.Time) {
+		expiry = t
+	}))
+
+	var bar *progress.Bar
+	if progress.IsTerminal(os.Stderr) {
+		bar = progress.NewBar(os.Stderr)
+		opts = append(opts, relay.WithSendProgress(bar.Update))
+	}
+
+	clientOpts, err := clientOptions()
+	if err != nil {
+		return err
+	}
+	words, ok := relay.Passwords(*language)
+	if !ok {
+		return fmt.Errorf("no wordlist for language %q (have %v)", *language, strings.Join(relay.Languages(), ", "))
+	}
+	clientOpts = append(clientOpts, relay.WithPasswords(words))
+
+	client := relay.NewClient(addr, clientOpts...)
+	secret, send, err := client.Offer(name, file, opts...)
 	if err != nil {
 		return fmt.Errorf("creating stream: %w", err)
 	}
 
 	fmt.Println(secret)
-	return send()
+	if !expiry.IsZero() {
+		fmt.Fprintf(os.Stderr, "expires %v (in %v)\n", expiry.Local().Format("15:04:05"), time.Until(expiry).Round(time.Second))
+	}
+
+	ctx, interrupted, cancelled := cancelOnInterrupt(client, secret)
+	err = send(ctx)
+	if bar != nil {
+		bar.Finish()
+	}
+	if err != nil {
+		select {
+		case <-interrupted:
+			return <-cancelled
+		default:
+			return err
+		}
+	}
+
+	if sum != nil {
+		fmt.Fprintf(os.Stderr, "sha256 %x\n", sum)
+	}
+	return nil
+}
+
+// cancelOnInterrupt revokes the offer on Ctrl-C or SIGTERM, so that its secret stops working right away.
+// The interrupted channel is closed first, then cancelled receives the outcome to report,
+// and finally the returned context is cancelled in case the relay couldn't be reached.
+func cancelOnInterrupt(client *relay.Client, secret string) (ctx context.Context, interrupted <-chan struct{}, cancelled <-chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopping := make(chan struct{})
+	outcome := make(chan error, 1)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		signal.Stop(signals) // a second Ctrl-C exits immediately
+		close(stopping)
+
+		if err := client.Cancel(secret); err != nil {
+			outcome <- fmt.Errorf("interrupted, but the offer may still be valid: %w", err)
+		} else {
+			outcome <- errors.New("offer cancelled")
+		}
+		cancel()
+	}()
+	return ctx, stopping, outcome
+}
+
+// open returns a single file as-is, or streams directories and multiple paths as a tar archive.
+func open(paths []string) (name string, file io.ReadCloser, opts []relay.OfferOption, err error) {
+	for _, p := range paths {
+		if _, err := os.Stat(p); err != nil {
+			return "", nil, nil, fmt.Errorf("opening file %v: %w", p, err)
+		}
+	}
+
+	if len(paths) == 1 {
+		info, _ := os.Stat(paths[0])
+		_, name = filepath.Split(filepath.Clean(paths[0]))
+		if info.Mode().IsRegular() {
+			file, err := os.Open(paths[0])
+			if err != nil {
+				return "", nil, nil, fmt.Errorf("opening file %v: %w", paths[0], err)
+			}
+			return name, file, []relay.OfferOption{relay.WithContentType(contentType(name))}, nil
+		}
+	} else {
+		name = "files"
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(archive.Write(w, paths...))
+	}()
+
+	return name + ".tar", r, []relay.OfferOption{relay.WithContentType(archive.ContentType)}, nil
+}
+
+// contentType guesses a file's MIME type from its extension.
+// A single file is never offered as an archive, since the receiver would unpack it.
+func contentType(name string) string {
+	t := mime.TypeByExtension(filepath.Ext(name))
+	if t == "" || t == archive.ContentType {
+		return "application/octet-stream"
+	}
+	return t
+}
+
+func clientOptions() ([]relay.ClientOption, error) {
+	switch {
+	case *pin != "":
+		config, err := relay.PinnedTLSConfig(*pin)
+		if err != nil {
+			return nil, err
+		}
+		return []relay.ClientOption{relay.WithTLSConfig(config)}, nil
+
+	case *caFile != "":
+		config, err := relay.CAFileTLSConfig(*caFile)
+		if err != nil {
+			return nil, err
+		}
+		return []relay.ClientOption{relay.WithTLSConfig(config)}, nil
+	}
+
+	return nil, nil
 }
@@ -1,9 +1,13 @@
 package integration
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
-	"math/rand"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
 	"time"
@@ -17,12 +21,11 @@ func TestIntegrationSimple(t *testing.T) {
 	contents := "file contents"
 
 	go func() {
-		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
-		secrets := relay.NewSecrets(rng)
+		secrets, _ := relay.NewSecrets()
 		handler := relay.NewHandler(secrets, ioutil.Discard)
 
 		if err := http.ListenAndServe(addr, handler); err != nil {
-			t.Errorf("starting server: %w", err)
+			t.Errorf("starting server: %v", err)
 		}
 	}()
 
@@ -36,22 +39,22 @@ func TestIntegrationSimple(t *testing.T) {
 		sender := relay.NewClient(addr)
 		secret, send, err := sender.Offer(filename, file)
 		if err != nil {
-			t.Errorf("opening send stream: %w", err)
+			t.Errorf("opening send stream: %v", err)
 		}
 
 		sharedSecret = secret
 		close(offering)
-		if err := send(); err != nil {
-			t.Errorf("send err: %w", err)
+		if err := send(context.Background()); err != nil {
+			t.Errorf("send err: %v", err)
 		}
 	}()
 
 	<-offering
 
 	receiver := relay.NewClient(addr)
-	suggestedName, stream, err := receiver.Receive(sharedSecret)
+	meta, stream, err := receiver.Receive(sharedSecret)
 	if err != nil {
-		t.Errorf("receiving: %w", err)
+		t.Errorf("receiving: %v", err)
 	}
 
 	received, err := ioutil.ReadAll(stream)
@@ -60,7 +63,7 @@ func TestIntegrationSimple(t *testing.T) {
 	}
 
 	t.Run("suggests a filename", func(t *testing.T) {
-		got := suggestedName
+		got := meta.Filename
 		want := filename
 
 		if got != want {
@@ -77,3 +80,99 @@ func TestIntegrationSimple(t *testing.T) {
 		}
 	})
 }
+
+// startNode runs a relay node that shares offers with the rest of a cluster.
+func startNode(t *testing.T, store relay.OfferStore, secrets fmt.Stringer) *httptest.Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := relay.NewHandler(secrets, ioutil.Discard, relay.WithCluster(relay.Cluster{
+		Store: store,
+		Self:  "http://" + l.Addr().String(),
+		Key:   "cluster-key",
+	}))
+
+	server := &httptest.Server{Listener: l, Config: &http.Server{Handler: handler}}
+	server.Start()
+	return server
+}
+
+func TestIntegrationCluster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storj-cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := relay.NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secretsA, _ := relay.NewSecrets()
+	nodeA := startNode(t, store, secretsA)
+	defer nodeA.Close()
+	secretsB, _ := relay.NewSecrets()
+	nodeB := startNode(t, store, secretsB)
+	defer nodeB.Close()
+
+	contents := strings.Repeat("file contents ", 100000)
+	file := ioutil.NopCloser(strings.NewReader(contents))
+
+	sender := relay.NewClient(nodeA.URL)
+	secret, send, err := sender.Offer("file.txt", file)
+	if err != nil {
+		t.Fatal("offering:", err)
+	}
+	// the nameplate is what's left of the secret without the sender's two password words
+	words := strings.Split(secret, "-")
+	nameplate := strings.Join(words[:len(words)-2], "-")
+	if _, err := store.Lookup(nameplate); err != nil {
+		t.Fatalf("looking up nameplate %q: %v", nameplate, err)
+	}
+
+	sent := make(chan error, 1)
+	go func() {
+		sent <- send(context.Background())
+	}()
+
+	receiver := relay.NewClient(nodeB.URL)
+	meta, stream, err := receiver.Receive(secret)
+	if err != nil {
+		t.Fatal("receiving:", err)
+	}
+	received, err := ioutil.ReadAll(stream)
+	if err != nil {
+		t.Fatal("reading stream:", err)
+	}
+
+	t.Run("receives the file through another node", func(t *testing.T) {
+		if string(received) != contents {
+			t.Errorf("got %v bytes, want %v", len(received), len(contents))
+		}
+		if meta.Filename != "file.txt" {
+			t.Errorf("got filename %q, want %q", meta.Filename, "file.txt")
+		}
+	})
+
+	t.Run("completes the send", func(t *testing.T) {
+		if err := <-sent; err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("releases the secret", func(t *testing.T) {
+		deadline := time.Now().Add(time.Second)
+		for {
+			_, err := store.Lookup(nameplate)
+			if err == relay.ErrNoOffer {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("got %v, want %v", err, relay.ErrNoOffer)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
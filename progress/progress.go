@@ -0,0 +1,103 @@
+// Package progress renders the progress of a transfer as a single, continually redrawn line on a terminal.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hunterloftis/storj/relay"
+)
+
+const (
+	barWidth = 30
+	interval = 100 * time.Millisecond
+)
+
+// Bar draws a progress bar for a transfer.
+type Bar struct {
+	w io.Writer
+
+	sync.Mutex
+	last   time.Time
+	latest relay.Progress
+	drawn  bool
+}
+
+// NewBar returns a Bar that draws to w, which should be a terminal.
+func NewBar(w io.Writer) *Bar {
+	return &Bar{w: w}
+}
+
+// Update records the transfer's progress, redrawing the bar at most ten times a second.
+// It can be passed directly to relay.WithSendProgress or relay.WithReceiveProgress.
+func (b *Bar) Update(p relay.Progress) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.latest = p
+	if now := time.Now(); now.Sub(b.last) >= interval {
+		b.last = now
+		b.draw()
+	}
+}
+
+// Finish draws the final state of the bar and ends its line.
+func (b *Bar) Finish() {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.drawn {
+		b.draw()
+		fmt.Fprintln(b.w)
+	}
+}
+
+func (b *Bar) draw() {
+	b.drawn = true
+	fmt.Fprintf(b.w, "\r%s\x1b[K", Format(b.latest))
+}
+
+// Format describes progress in a single line, with a bar and percentage when the total is known.
+func Format(p relay.Progress) string {
+	rate := Bytes(int64(p.Rate)) + "/s"
+	if p.Total <= 0 {
+		return fmt.Sprintf("%v  %v", Bytes(p.Done), rate)
+	}
+
+	fraction := float64(p.Done) / float64(p.Total)
+	if fraction > 1 {
+		fraction = 1
+	}
+	filled := int(fraction * barWidth)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+
+	line := fmt.Sprintf("[%v] %3.0f%%  %v / %v  %v", bar, fraction*100, Bytes(p.Done), Bytes(p.Total), rate)
+	if p.ETA > 0 && p.Done < p.Total {
+		line += "  ETA " + p.ETA.Round(time.Second).String()
+	}
+	return line
+}
+
+// Bytes formats a number of bytes with a binary unit, such as "1.5 MiB".
+func Bytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// IsTerminal reports whether f is attached to a terminal rather than a file or pipe.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,63 @@
+package progress
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hunterloftis/storj/relay"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		p    relay.Progress
+		want string
+	}{
+		{
+			name: "known total",
+			p:    relay.Progress{Done: 512 * 1024, Total: 1024 * 1024, Rate: 1024, ETA: 512 * time.Second},
+			want: "[===============               ]  50%  512.0 KiB / 1.0 MiB  1.0 KiB/s  ETA 8m32s",
+		},
+		{
+			name: "unknown total",
+			p:    relay.Progress{Done: 1500, Total: -1, Rate: 100},
+			want: "1.5 KiB  100 B/s",
+		},
+		{
+			name: "complete",
+			p:    relay.Progress{Done: 10, Total: 10, Rate: 10},
+			want: "[==============================] 100%  10 B / 10 B  10 B/s",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Format(test.p); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBar(t *testing.T) {
+	var out bytes.Buffer
+	bar := NewBar(&out)
+
+	bar.Update(relay.Progress{Done: 1, Total: 10})
+	bar.Update(relay.Progress{Done: 5, Total: 10}) // too soon to redraw
+	bar.Finish()
+
+	t.Run("throttles redraws", func(t *testing.T) {
+		if got := strings.Count(out.String(), "\r"); got != 2 {
+			t.Errorf("drew %v times, want 2", got)
+		}
+	})
+
+	t.Run("finishes with the latest progress", func(t *testing.T) {
+		if !strings.HasSuffix(out.String(), Format(relay.Progress{Done: 5, Total: 10})+"\x1b[K\n") {
+			t.Errorf("got %q", out.String())
+		}
+	})
+}
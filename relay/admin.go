@@ -0,0 +1,140 @@
+package relay
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Offer states reported by the admin API.
+const (
+	StateWaiting      = "waiting"
+	StateTransferring = "transferring"
+)
+
+// OfferInfo describes an offer held by a Handler, for operators.
+type OfferInfo struct {
+	// ID identifies the offer in logs and the admin API.
+	ID string `json:"id"`
+	// Secret is the offer's code, masked so that operators never see it.
+	Secret string `json:"secret"`
+	// Created is when the sender made the offer.
+	Created time.Time `json:"created"`
+	// Age is how long ago the offer was created, in whole seconds.
+	Age int64 `json:"age_seconds"`
+	// Filename is suggested by the sender.
+	Filename string `json:"filename"`
+	// Size is the length of the file in bytes, or -1 if the sender didn't advertise it.
+	Size int64 `json:"size"`
+	// Sender is the sender's address.
+	Sender string `json:"sender"`
+	// State is StateTransferring while the sender is streaming to a receiver, and StateWaiting otherwise.
+	State string `json:"state"`
+	// Bytes counts the encrypted bytes streamed to receivers so far, including any resent after a resume.
+	Bytes int64 `json:"bytes"`
+}
+
+// Offers lists the offers held by the handler, oldest first.
+// In a cluster, each node lists only its own offers.
+func (h *Handler) Offers() []OfferInfo {
+	h.RLock()
+	defer h.RUnlock()
+
+	now := time.Now()
+	infos := make([]OfferInfo, 0, len(h.offers))
+	for secret, off := range h.offers {
+		off.Lock()
+		state := StateWaiting
+		if off.streaming {
+			state = StateTransferring
+		}
+		off.Unlock()
+
+		infos = append(infos, OfferInfo{
+			ID:       off.id,
+			Secret:   maskSecret(secret),
+			Created:  off.created,
+			Age:      int64(now.Sub(off.created) / time.Second),
+			Filename: off.meta.Filename,
+			Size:     off.meta.Size,
+			Sender:   off.address,
+			State:    state,
+			Bytes:    atomic.LoadInt64(&off.moved),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Created.Before(infos[j].Created)
+	})
+	return infos
+}
+
+// Cancel forcibly aborts the offer with the given ID, failing both sides' requests with 410 Gone.
+// It reports whether the handler held such an offer.
+func (h *Handler) Cancel(id string) bool {
+	h.RLock()
+	defer h.RUnlock()
+
+	for _, off := range h.offers {
+		if off.id == id && off.ctx.Err() == nil {
+			h.logger.Log(LevelWarn, "transfer cancelled", "transfer", id, "by", "admin")
+			off.abort()
+			return true
+		}
+	}
+	return false
+}
+
+// Admin returns an HTTP handler for operators, which should be served on a separate, private listener:
+//
+//	GET /offers          lists the offers as a JSON array of OfferInfo
+//	DELETE /offers/{id}  cancels an offer
+//
+// Every request must be authorized with the header "Authorization: Bearer {key}".
+// An empty key refuses every request.
+func (h *Handler) Admin(key string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, key) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/offers":
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(h.Offers()); err != nil {
+				h.logger.Log(LevelWarn, "listing offers failed", "error", err)
+			}
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/offers/"):
+			if !h.Cancel(strings.TrimPrefix(r.URL.Path, "/offers/")) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+// authorized reports whether r carries the bearer key.
+func authorized(r *http.Request, key string) bool {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if key == "" || !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+	return hmac.Equal([]byte(strings.TrimPrefix(auth, prefix)), []byte(key))
+}
+
+// maskSecret hides all but the first letter of a secret.
+func maskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return secret[:1] + "********"
+}
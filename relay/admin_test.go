@@ -0,0 +1,81 @@
+package relay
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerAdmin(t *testing.T) {
+	const secret = "some-secret-string"
+	const key = "admin-key"
+
+	handler := NewHandler(newSecretList(secret), ioutil.Discard)
+	admin := handler.Admin(key)
+
+	request, _ := http.NewRequest(http.MethodPost, "/file", nil)
+	request.Header.Set(filenameHeader, "filename.txt")
+	token := post(handler, request)
+
+	sent := make(chan int)
+	go func() {
+		request, _ := http.NewRequest(http.MethodPut, "/file/"+secret, strings.NewReader("file contents"))
+		request.Header.Set(senderTokenHeader, token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		sent <- w.Code
+	}()
+
+	adminRequest := func(method, path, key string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, path, nil)
+		if key != "" {
+			request.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, request)
+		return w
+	}
+
+	t.Run("requires the key", func(t *testing.T) {
+		for _, key := range []string{"", "wrong-key"} {
+			if got, want := adminRequest(http.MethodGet, "/offers", key).Code, http.StatusUnauthorized; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		}
+	})
+
+	var infos []OfferInfo
+	t.Run("lists offers", func(t *testing.T) {
+		w := adminRequest(http.MethodGet, "/offers", key)
+		if err := json.Unmarshal(w.Body.Bytes(), &infos); err != nil {
+			t.Fatal(err)
+		}
+		if len(infos) != 1 {
+			t.Fatalf("got %v offers, want 1", len(infos))
+		}
+
+		info := infos[0]
+		if info.Filename != "filename.txt" || info.State != StateWaiting || info.Bytes != 0 || info.ID == "" {
+			t.Errorf("got %+v", info)
+		}
+		if strings.Contains(w.Body.String(), secret) {
+			t.Errorf("secret in %v", w.Body.String())
+		}
+	})
+
+	t.Run("cancels offers", func(t *testing.T) {
+		if got, want := adminRequest(http.MethodDelete, "/offers/"+infos[0].ID, key).Code, http.StatusNoContent; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		// depending on whether the sender was already waiting, or arrived after the offer was gone
+		if got := <-sent; got != http.StatusGone && got != http.StatusNotFound {
+			t.Errorf("sender got %v, want %v or %v", got, http.StatusGone, http.StatusNotFound)
+		}
+		if got, want := adminRequest(http.MethodDelete, "/offers/"+infos[0].ID, key).Code, http.StatusNotFound; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
@@ -1,89 +1,449 @@
 package relay
 
 import (
-	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
-	proto = "http://"
+	// passwordWords is the number of words the sender appends to the relay's secret.
+	// They never leave the clients and key the end-to-end encryption of the transfer.
+	passwordWords = 2
+
+	// abortTimeout bounds how long an abandoned transfer spends telling the relay.
+	abortTimeout = 5 * time.Second
 )
 
 // SendFn is a function that blocks until a file being sent has been completely downloaded.
-type SendFn func() error
+//
+// Cancelling ctx abandons the transfer, which promptly fails the receiver too.
+type SendFn func(ctx context.Context) error
+
+// ErrAborted is returned when the other side of a transfer abandons it.
+var ErrAborted = errors.New("transfer aborted by peer")
+
+// ErrDeclined is returned when the receiver turns down an offer after seeing its metadata.
+var ErrDeclined = errors.New("offer declined by receiver")
+
+// ErrStalled is returned when the relay aborts a transfer that stopped making progress.
+var ErrStalled = errors.New("transfer stalled")
+
+// ErrTooManyRequests is returned when the relay refuses a request because the client has too many offers,
+// the relay has too many transfers in progress, or the client has been locked out after too many failures.
+var ErrTooManyRequests = errors.New("too many requests")
+
+// ErrTooLarge is returned when a file exceeds the relay's size limit.
+var ErrTooLarge = errors.New("file too large for relay")
 
 // Client can send to or receive from a relay server.
+//
+// Transfers are end-to-end encrypted: the relay only ever sees ciphertext.
 type Client struct {
-	addr string
+	base      string
+	http      *http.Client
+	tlsConfig *tls.Config
+	passwords *rand.Rand
+	words     PasswordWords
+
+	sync.Mutex
+	offers map[string]string // nameplates of this client's offers, to the tokens that authorize their sender
 }
 
 // NewClient creates a new Client that will communicate with the server at the specified address.
-func NewClient(addr string) *Client {
-	return &Client{
-		addr: addr,
+//
+// The address may be a "host:port" pair, which is reached over plain HTTP unless a TLS
+// configuration is provided, or a URL such as "https://host:port".
+func NewClient(addr string, opts ...ClientOption) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	c := &Client{
+		http:      &http.Client{Transport: transport},
+		passwords: rand.New(cryptoSource{}),
+		words:     englishPasswords,
+		offers:    make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.tlsConfig != nil {
+		transport.TLSClientConfig = c.tlsConfig
+	}
+
+	switch {
+	case strings.Contains(addr, "://"):
+		c.base = strings.TrimSuffix(addr, "/")
+	case c.tlsConfig != nil:
+		c.base = "https://" + addr
+	default:
+		c.base = "http://" + addr
 	}
+	return c
 }
 
-// Offer offers a file, with a proposed filename, to a recipient via the relay server.
+// Offer is OfferContext with the background context.
+func (c *Client) Offer(filename string, file io.ReadCloser, opts ...OfferOption) (secret string, send SendFn, err error) {
+	return c.OfferContext(context.Background(), filename, file, opts...)
+}
+
+// OfferContext offers a file, with a proposed filename, to a recipient via the relay server.
 //
 // It does not block on sending the file, but instead returns the file's secret immediately
 // along with a blocking function to send the file's contents.
+// The secret combines the relay's code for the offer with a password generated locally,
+// which is used to agree on an encryption key with the receiver.
+// If file is an *os.File, its size, modification time and permissions are offered along with it.
 //
-//	secret, send, _ := client.Offer(filename, file)
-// 	fmt.Println(secret)	// immediately show the secret
-//	_ = send()					// wait for the file to be sent
-func (c *Client) Offer(filename string, file io.ReadCloser) (secret string, send SendFn, err error) {
-	req, _ := http.NewRequest(http.MethodPost, proto+c.addr+"/file", nil)
-	req.Header.Set(filenameHeader, filename)
-
-	resp, err := http.DefaultClient.Do(req)
+// The context only applies to making the offer; the transfer is governed by the context passed to send.
+//
+//	secret, send, _ := client.OfferContext(ctx, filename, file, relay.WithContentType("image/jpeg"))
+//	fmt.Println(secret)	// immediately show the secret
+//	_ = send(ctx)				// wait for the file to be sent
+func (c *Client) OfferContext(ctx context.Context, filename string, file io.ReadCloser, opts ...OfferOption) (secret string, send SendFn, err error) {
+	options := offerOptions{meta: Metadata{Filename: filename, Size: -1}}
+	if stat, ok := file.(interface{ Stat() (os.FileInfo, error) }); ok {
+		if info, err := stat.Stat(); err == nil && info.Mode().IsRegular() {
+			WithFileInfo(info)(&options)
+		}
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	password := c.words.newPassword(c.passwords)
+	pake, err := newSpake2(spakeSender, password)
+	if err != nil {
+		return "", nil, fmt.Errorf("starting key exchange: %w", err)
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, c.base+"/file", nil)
+	options.meta.writeHeader(req.Header)
+	req.Header.Set(exchangeHeader, pake.message())
+	if options.expiresIn > 0 {
+		seconds := (options.expiresIn + time.Second - 1) / time.Second
+		req.Header.Set(expiresInHeader, strconv.FormatInt(int64(seconds), 10))
+	}
+
+	resp, err := c.http.Do(req)
 	if err != nil {
 		return "", nil, fmt.Errorf("posting to offer: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return "", nil, fmt.Errorf("bad status code on offer: %v", resp.StatusCode)
+	if err := checkStatus(resp, "on offer"); err != nil {
+		return "", nil, err
 	}
 
-	limited := io.LimitReader(resp.Body, 100)
-	secret, err = bufio.NewReader(limited).ReadString('\n')
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 100))
 	if err != nil {
 		return "", nil, fmt.Errorf("reading secret from offer: %w", err)
 	}
-	secret = strings.TrimSpace(secret)
+	nameplate := strings.TrimSpace(string(body))
+	c.Lock()
+	c.offers[nameplate] = resp.Header.Get(senderTokenHeader)
+	c.Unlock()
+
+	if options.expiresFn != nil {
+		if expires, err := time.Parse(time.RFC3339, resp.Header.Get(expiresHeader)); err == nil {
+			options.expiresFn(expires)
+		}
+	}
 
-	send = func() error {
-		// TODO: make this a request WithContext (req = req.WithContext(ctx))
-		// Then cancel the context whenever the receiver disconnects.
-		// Ditto in reverse, if that doesn't already happen from the ending of the stream...
-		req, _ := http.NewRequest(http.MethodPut, proto+c.addr+"/file/"+secret, file)
-		resp, err := http.DefaultClient.Do(req)
+	send = func(ctx context.Context) error {
+		defer file.Close()
+		defer c.forget(nameplate)
+
+		var sum []byte
+		key, err := c.accept(ctx, nameplate, pake)
+		if err == nil {
+			sum, err = c.upload(ctx, nameplate, key, file, newMeter(options.progressFn, options.meta.Size))
+		}
 		if err != nil {
+			if ctx.Err() != nil {
+				c.abort(nameplate, "")
+			}
 			return err
 		}
-		return resp.Body.Close()
+		if options.digestFn != nil {
+			options.digestFn(sum)
+		}
+		return nil
+	}
+
+	return nameplate + "-" + password, send, nil
+}
+
+// Receive is ReceiveContext with the background context.
+func (c *Client) Receive(secret string, opts ...ReceiveOption) (meta Metadata, stream io.ReadCloser, err error) {
+	return c.ReceiveContext(context.Background(), secret, opts...)
+}
+
+// ReceiveContext receives a file stored with the given secret.
+//
+// It returns immediately with the file's metadata and a stream from which to read the file contents.
+// The metadata has been provided by the sender and should not be trusted without validation.
+// If the secret's password doesn't match the sender's, it returns ErrWrongSecret.
+// With WithConfirm, the receiver can see the metadata and decline the offer before reading anything.
+// The relay only reveals the metadata, and lets the receiver decline, once the sender has accepted its key confirmation.
+//
+// If the connection drops midway, reading from the stream transparently reconnects and resumes
+// from the last byte read, as long as the sender can do the same.
+// At the end of the stream, it verifies the file's SHA-256 against the sender's, returning
+// ErrDigestMismatch instead of io.EOF if they differ. The stream implements Digester.
+//
+// Cancelling ctx, or closing the stream before it's been read to the end, abandons the transfer,
+// which promptly fails the sender too.
+func (c *Client) ReceiveContext(ctx context.Context, secret string, opts ...ReceiveOption) (meta Metadata, stream io.ReadCloser, err error) {
+	var options receiveOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	nameplate, password, err := splitSecret(secret)
+	if err != nil {
+		return Metadata{}, nil, err
+	}
+	pake, err := newSpake2(spakeReceiver, password)
+	if err != nil {
+		return Metadata{}, nil, fmt.Errorf("starting key exchange: %w", err)
+	}
+
+	// claim the offer to learn the sender's key exchange message
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, c.base+"/file/"+nameplate+"/claim", nil)
+	req.Header.Set(exchangeHeader, pake.message())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return Metadata{}, nil, fmt.Errorf("claiming: %w", err)
+	}
+	resp.Body.Close()
+	if err := checkStatus(resp, "claiming"); err != nil {
+		return Metadata{}, nil, err
+	}
+	token := resp.Header.Get(receiverTokenHeader)
+
+	key, err := pake.finish(nameplate, resp.Header.Get(exchangeHeader))
+	if err != nil {
+		return Metadata{}, nil, fmt.Errorf("exchanging keys: %w", err)
+	}
+
+	// join with proof of the key, which the sender checks before streaming anything
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, c.base+"/file/"+nameplate, nil)
+	req.Header.Set(receiverTokenHeader, token)
+	req.Header.Set(confirmationHeader, keyConfirmation(key))
+	if options.confirmFn != nil {
+		req.Header.Set(confirmsHeader, "true")
+	}
+
+	resp, err = c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			c.abort(nameplate, token)
+		}
+		return Metadata{}, nil, fmt.Errorf("receiving: %w", err)
+	}
+	if resp.StatusCode == http.StatusForbidden {
+		resp.Body.Close()
+		return Metadata{}, nil, ErrWrongSecret
+	}
+	if err := checkStatus(resp, "receiving"); err != nil {
+		resp.Body.Close()
+		return Metadata{}, nil, err
+	}
+
+	// the relay only sends the metadata once the sender has checked the key confirmation,
+	// and when asked to, waits for the receiver to accept it before streaming anything
+	meta = readMetadata(resp.Header)
+	if options.confirmFn != nil && !options.confirmFn(meta) {
+		err := c.decide(ctx, nameplate, token, "decline")
+		resp.Body.Close()
+		if err != nil {
+			return Metadata{}, nil, fmt.Errorf("declining: %w", err)
+		}
+		return meta, nil, ErrDeclined
+	}
+	if options.confirmFn != nil {
+		if err := c.decide(ctx, nameplate, token, "accept"); err != nil {
+			resp.Body.Close()
+			return Metadata{}, nil, fmt.Errorf("accepting: %w", err)
+		}
+	}
+
+	opened, err := newOpener(resp.Body, key, 0)
+	if err != nil {
+		resp.Body.Close()
+		if ctx.Err() != nil {
+			c.abort(nameplate, token)
+		}
+		return Metadata{}, nil, fmt.Errorf("decrypting: %w", err)
+	}
+
+	d := &download{
+		c:         c,
+		ctx:       ctx,
+		nameplate: nameplate,
+		token:     token,
+		key:       key,
+		stream:    opened,
+		meter:     newMeter(options.progressFn, meta.Size),
+		hash:      sha256.New(),
+	}
+	return meta, d, nil
+}
+
+// accept waits for a receiver whose key confirmation proves it used the right code,
+// and returns the key they share. Receivers with the wrong code are rejected,
+// until the relay destroys the offer for having too many of them.
+func (c *Client) accept(ctx context.Context, nameplate string, pake *spake2) ([]byte, error) {
+	for {
+		j, err := c.handshake(ctx, nameplate)
+		if err != nil {
+			return nil, fmt.Errorf("waiting for receiver: %w", err)
+		}
+		key, err := pake.finish(nameplate, j.exchange)
+		if err == nil && hmac.Equal([]byte(j.confirmation), []byte(keyConfirmation(key))) {
+			return key, nil
+		}
+		if err := c.reject(ctx, nameplate); err != nil {
+			return nil, fmt.Errorf("rejecting receiver: %w", err)
+		}
 	}
+}
 
-	return secret, send, nil
+// reject tells the relay that the receiver from the last handshake used the wrong code.
+func (c *Client) reject(ctx context.Context, nameplate string) error {
+	req := c.senderRequest(ctx, http.MethodDelete, nameplate, "/claim", nil)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp, "on reject")
 }
 
-// Receive receives a file stored with the given secret.
+// handshake blocks until a receiver joins the offer, then returns the receiver's
+// key exchange message and confirmation, or the offset from which it's resuming.
+func (c *Client) handshake(ctx context.Context, nameplate string) (join, error) {
+	req := c.senderRequest(ctx, http.MethodGet, nameplate, "/handshake", nil)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return join{}, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkStatus(resp, "on handshake"); err != nil {
+		return join{}, err
+	}
+	return join{
+		exchange:     resp.Header.Get(exchangeHeader),
+		confirmation: resp.Header.Get(confirmationHeader),
+		offset:       resp.Header.Get(resumeHeader),
+	}, nil
+}
+
+// Cancel is CancelContext with the background context.
+func (c *Client) Cancel(secret string) error {
+	return c.CancelContext(context.Background(), secret)
+}
+
+// CancelContext revokes an offer made by this client, so that its secret stops working immediately.
+// Offers can be cancelled until their send function returns.
+// A transfer in progress is abandoned: the receiver fails with ErrAborted, and so does the offer's send function
+// unless it was between requests, in which case it fails because the offer no longer exists.
 //
-// It returns immediately with a proposed filename and a stream from which to read the file contents.
-// The filename has been suggested by the sender and should not be trusted without validation.
-func (c *Client) Receive(secret string) (filename string, stream io.ReadCloser, err error) {
-	endpoint := proto + c.addr + "/file/" + secret
-	resp, err := http.DefaultClient.Get(endpoint)
+// The relay only accepts cancellations from the offer's sender.
+func (c *Client) CancelContext(ctx context.Context, secret string) error {
+	nameplate, _, err := splitSecret(secret)
 	if err != nil {
-		return "", nil, fmt.Errorf("receiving: %w", err)
+		return err
 	}
-	if resp.StatusCode < 200 || resp.StatusCode > 299 {
-		return "", nil, fmt.Errorf("bad status code receiving: %v", resp.StatusCode)
+	c.Lock()
+	_, ok := c.offers[nameplate]
+	c.Unlock()
+	if !ok {
+		return errors.New("cancelling offer: not offered by this client")
 	}
+	if err := c.delete(ctx, nameplate, ""); err != nil {
+		return fmt.Errorf("cancelling offer: %w", err)
+	}
+	return nil
+}
 
-	return resp.Header.Get(filenameHeader), resp.Body, nil
+// abort tells the relay that a transfer has been abandoned, so that the other side
+// fails promptly rather than waiting for it to resume.
+// Receivers identify themselves with their token, and senders with none.
+func (c *Client) abort(nameplate, token string) {
+	// the transfer's context is likely done already, so the request needs its own
+	ctx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+	defer cancel()
+
+	_ = c.delete(ctx, nameplate, token)
+}
+
+// delete asks the relay to destroy an offer, on behalf of its sender or, with a token, its receiver.
+func (c *Client) delete(ctx context.Context, nameplate, token string) error {
+	req := c.senderRequest(ctx, http.MethodDelete, nameplate, "", nil)
+	if token != "" {
+		req.Header.Del(senderTokenHeader)
+		req.Header.Set(receiverTokenHeader, token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp, "on delete")
+}
+
+// decide tells the relay whether the receiver holding token will "accept" or "decline" the offer.
+func (c *Client) decide(ctx context.Context, nameplate, token, decision string) error {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, c.base+"/file/"+nameplate+"/"+decision, nil)
+	req.Header.Set(receiverTokenHeader, token)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp, "on "+decision)
+}
+
+// senderRequest returns a request for path beneath an offer, authorized as its sender if this client made it.
+func (c *Client) senderRequest(ctx context.Context, method, nameplate, path string, body io.Reader) *http.Request {
+	req, _ := http.NewRequestWithContext(ctx, method, c.base+"/file/"+nameplate+path, body)
+
+	c.Lock()
+	defer c.Unlock()
+	if token, ok := c.offers[nameplate]; ok {
+		req.Header.Set(senderTokenHeader, token)
+	}
+	return req
+}
+
+// forget stops tracking an offer once it's been sent.
+func (c *Client) forget(nameplate string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.offers, nameplate)
+}
+
+// splitSecret separates the relay's code for an offer from the password known only to the clients.
+func splitSecret(secret string) (nameplate, password string, err error) {
+	parts := strings.Split(secret, "-")
+	if len(parts) <= passwordWords {
+		return "", "", fmt.Errorf("malformed secret: %q", secret)
+	}
+	n := len(parts) - passwordWords
+	return strings.Join(parts[:n], "-"), strings.Join(parts[n:], "-"), nil
 }
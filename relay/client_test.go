@@ -2,23 +2,31 @@ package relay
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestClientSend(t *testing.T) {
 	const secret = "some-secret-string"
 	const filename = "file.txt"
+	const contentType = "text/plain"
 	const contents = "file contents"
 
 	var request1 *http.Request
 	var request2 *http.Request
+	var receiver *spake2
 
 	file := ioutil.NopCloser(strings.NewReader(contents))
 	sent := bytes.NewBuffer([]byte{})
@@ -27,9 +35,14 @@ func TestClientSend(t *testing.T) {
 		switch r.Method {
 		case http.MethodPost:
 			request1 = r
+			w.Header().Set(senderTokenHeader, "sender-token")
 			if _, err := io.Copy(w, strings.NewReader(secret+"\n")); err != nil {
 				t.Error("sending secret:", err)
 			}
+		case http.MethodGet:
+			key, _ := receiver.finish(secret, request1.Header.Get(exchangeHeader))
+			w.Header().Set(exchangeHeader, receiver.message())
+			w.Header().Set(confirmationHeader, keyConfirmation(key))
 		case http.MethodPut:
 			request2 = r
 			if _, err := io.Copy(sent, r.Body); err != nil {
@@ -41,7 +54,7 @@ func TestClientSend(t *testing.T) {
 	u, _ := url.Parse(server.URL)
 	client := NewClient(u.Host)
 
-	sec, send, err := client.Offer(filename, file)
+	sec, send, err := client.Offer(filename, file, WithContentType(contentType))
 	if err != nil {
 		t.Error("client.Offer:", err)
 	}
@@ -64,15 +77,29 @@ func TestClientSend(t *testing.T) {
 		}
 	})
 
-	t.Run("returns secret", func(t *testing.T) {
-		got := sec
-		want := secret
+	t.Run("offers content type", func(t *testing.T) {
+		got := request1.Header.Get(contentTypeHeader)
+		want := contentType
 
 		if got != want {
 			t.Errorf("got %q, want %q", got, want)
 		}
 	})
 
+	t.Run("returns secret with password", func(t *testing.T) {
+		nameplate, password, err := splitSecret(sec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if nameplate != secret {
+			t.Errorf("got %q, want %q", nameplate, secret)
+		}
+		if got := len(strings.Split(password, "-")); got != passwordWords {
+			t.Errorf("got %v password words, want %v", got, passwordWords)
+		}
+	})
+
 	t.Run("waits to stream", func(t *testing.T) {
 		got := sent.Len()
 		want := 0
@@ -82,8 +109,11 @@ func TestClientSend(t *testing.T) {
 		}
 	})
 
-	if err := send(); err != nil {
-		t.Errorf("send error: %w", err)
+	_, password, _ := splitSecret(sec)
+	receiver, _ = newSpake2(spakeReceiver, password)
+
+	if err := send(context.Background()); err != nil {
+		t.Errorf("send error: %v", err)
 	}
 
 	t.Run("PUTs to /file/{secret}", func(t *testing.T) {
@@ -95,49 +125,109 @@ func TestClientSend(t *testing.T) {
 		}
 	})
 
-	t.Run("streams file", func(t *testing.T) {
-		got := sent.String()
-		want := contents
+	t.Run("authorizes the PUT with the sender token", func(t *testing.T) {
+		got := request2.Header.Get(senderTokenHeader)
+		want := "sender-token"
 
 		if got != want {
 			t.Errorf("got %q, want %q", got, want)
 		}
 	})
+
+	t.Run("streams only ciphertext", func(t *testing.T) {
+		if strings.Contains(sent.String(), contents) {
+			t.Errorf("found plaintext in %q", sent.String())
+		}
+	})
+
+	t.Run("streams encrypted file", func(t *testing.T) {
+		key, err := receiver.finish(secret, request1.Header.Get(exchangeHeader))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream, err := newOpener(ioutil.NopCloser(sent), key, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := ioutil.ReadAll(stream)
+		want := contents
+
+		if string(got) != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
+// fakeSender plays the part of the relay and sender of a single offer,
+// streaming the reader returned by seal once the receiver has confirmed the key.
+func fakeSender(t *testing.T, nameplate, password string, meta Metadata, seal func(key []byte) io.Reader) http.HandlerFunc {
+	const token = "receiver-token"
+	sender, _ := newSpake2(spakeSender, password)
+	var key []byte
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			var err error
+			if key, err = sender.finish(nameplate, r.Header.Get(exchangeHeader)); err != nil {
+				t.Errorf("exchanging keys: %v", err)
+			}
+			w.Header().Set(exchangeHeader, sender.message())
+			w.Header().Set(receiverTokenHeader, token)
+		case http.MethodGet:
+			if r.Header.Get(receiverTokenHeader) != token {
+				t.Errorf("got token %q, want %q", r.Header.Get(receiverTokenHeader), token)
+			}
+			if r.Header.Get(confirmationHeader) != keyConfirmation(key) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			meta.writeHeader(w.Header())
+			if _, err := io.Copy(w, seal(key)); err != nil {
+				t.Errorf("copying file: %v", err)
+			}
+		}
+	}
 }
 
 func TestClientReceive(t *testing.T) {
-	const secret = "some-secret-string"
+	const nameplate = "some-secret-string"
+	const password = "little-earth"
+	const secret = nameplate + "-" + password
 	const filename = "file.txt"
+	const contentType = "text/plain"
 	const contents = "file contents"
 
 	file := ioutil.NopCloser(strings.NewReader(contents))
 
-	var request *http.Request
+	var requests []string
 
+	meta := Metadata{Filename: filename, ContentType: contentType}
+	sender := fakeSender(t, nameplate, password, meta, func(key []byte) io.Reader {
+		sealed, _ := newSealer(file, key, 0, newDigest())
+		return sealed
+	})
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		request = r
-		w.Header().Add(filenameHeader, filename)
-		if _, err := io.Copy(w, file); err != nil {
-			t.Errorf("copying file: %w", err)
-		}
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		sender(w, r)
 	}))
 
 	u, _ := url.Parse(server.URL)
 	client := NewClient(u.Host)
 
-	suggestedName, stream, err := client.Receive(secret)
+	meta, stream, err := client.Receive(secret)
 	if err != nil {
-		t.Errorf("receiving: %w", err)
+		t.Fatalf("receiving: %v", err)
 	}
 
 	received, err := ioutil.ReadAll(stream)
 	if err != nil {
-		t.Errorf("reading stream: %w", err)
+		t.Errorf("reading stream: %v", err)
 	}
 
-	t.Run("requests GET /file/{secret}", func(t *testing.T) {
-		got := request.Method + " " + request.URL.Path
-		want := "GET /file/" + secret
+	t.Run("claims then GETs /file/{secret}", func(t *testing.T) {
+		got := strings.Join(requests, ", ")
+		want := "POST /file/" + nameplate + "/claim, GET /file/" + nameplate
 
 		if got != want {
 			t.Errorf("got %q, want %q", got, want)
@@ -145,7 +235,7 @@ func TestClientReceive(t *testing.T) {
 	})
 
 	t.Run("returns suggested filename", func(t *testing.T) {
-		got := suggestedName
+		got := meta.Filename
 		want := filename
 
 		if got != want {
@@ -153,6 +243,15 @@ func TestClientReceive(t *testing.T) {
 		}
 	})
 
+	t.Run("returns content type", func(t *testing.T) {
+		got := meta.ContentType
+		want := contentType
+
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
 	t.Run("streams file", func(t *testing.T) {
 		got := fmt.Sprintf("%s", received)
 		want := contents
@@ -161,4 +260,715 @@ func TestClientReceive(t *testing.T) {
 			t.Errorf("got %q, want %q", got, want)
 		}
 	})
+
+	t.Run("verifies digest", func(t *testing.T) {
+		got := stream.(Digester).Digest()
+		want := sha256.Sum256([]byte(contents))
+
+		if !bytes.Equal(got, want[:]) {
+			t.Errorf("got %x, want %x", got, want)
+		}
+	})
+}
+
+func TestClientDigestMismatch(t *testing.T) {
+	const nameplate = "some-secret-string"
+	const password = "little-earth"
+
+	server := httptest.NewServer(fakeSender(t, nameplate, password, Metadata{}, func(key []byte) io.Reader {
+		// the sender's digest covers something other than what it sends
+		d := newDigest()
+		d.hash.Write([]byte("other contents"))
+		sealed, _ := newSealer(strings.NewReader("file contents"), key, 0, d)
+		return sealed
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	_, stream, err := NewClient(u.Host).Receive(nameplate + "-" + password)
+	if err != nil {
+		t.Fatalf("receiving: %v", err)
+	}
+	_, err = ioutil.ReadAll(stream)
+
+	t.Run("fails at the end of the stream", func(t *testing.T) {
+		if err != ErrDigestMismatch {
+			t.Errorf("got %v, want %v", err, ErrDigestMismatch)
+		}
+	})
+
+	t.Run("has no digest", func(t *testing.T) {
+		if got := stream.(Digester).Digest(); got != nil {
+			t.Errorf("got %x, want nil", got)
+		}
+	})
+}
+
+func TestClientWrongSecret(t *testing.T) {
+	const nameplate = "some-secret-string"
+
+	server := httptest.NewServer(NewHandler(newSecretList(nameplate), ioutil.Discard))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	sender := NewClient(u.Host)
+	receiver := NewClient(u.Host)
+
+	file := ioutil.NopCloser(strings.NewReader("file contents"))
+	secret, send, err := sender.Offer("file.txt", file)
+	if err != nil {
+		t.Fatal("client.Offer:", err)
+	}
+	sent := make(chan error)
+	go func() {
+		sent <- send(context.Background())
+	}()
+
+	_, stream, err := receiver.Receive(wrongSecret(secret))
+
+	t.Run("fails closed", func(t *testing.T) {
+		if stream != nil {
+			t.Error("got a stream, want nil")
+		}
+		if !errors.Is(err, ErrWrongSecret) {
+			t.Errorf("got %v, want %v", err, ErrWrongSecret)
+		}
+	})
+
+	t.Run("keeps the offer for the right secret", func(t *testing.T) {
+		_, stream, err := receiver.Receive(secret)
+		if err != nil {
+			t.Fatal("client.Receive:", err)
+		}
+		got, _ := ioutil.ReadAll(stream)
+		want := "file contents"
+
+		if string(got) != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if err := <-sent; err != nil {
+			t.Errorf("send error: %v", err)
+		}
+	})
+}
+
+func TestClientTooManyWrongSecrets(t *testing.T) {
+	const nameplate = "some-secret-string"
+
+	handler := NewHandler(newSecretList(nameplate), ioutil.Discard, WithLimits(Limits{WrongCodes: 2}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	sender := NewClient(u.Host)
+	receiver := NewClient(u.Host)
+
+	file := ioutil.NopCloser(strings.NewReader("file contents"))
+	secret, send, err := sender.Offer("file.txt", file)
+	if err != nil {
+		t.Fatal("client.Offer:", err)
+	}
+	sent := make(chan error)
+	go func() {
+		sent <- send(context.Background())
+	}()
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := receiver.Receive(wrongSecret(secret)); !errors.Is(err, ErrWrongSecret) {
+			t.Fatalf("got %v, want %v", err, ErrWrongSecret)
+		}
+	}
+
+	t.Run("destroys the offer", func(t *testing.T) {
+		_, _, err := receiver.Receive(secret)
+		var status statusError
+		if !errors.As(err, &status) || status.code != http.StatusNotFound {
+			t.Errorf("got %v, want status %v", err, http.StatusNotFound)
+		}
+	})
+
+	t.Run("fails the sender", func(t *testing.T) {
+		if err := <-sent; err == nil {
+			t.Error("got nil, want an error")
+		}
+	})
+}
+
+// wrongSecret returns a secret with the same nameplate, but a different password.
+func wrongSecret(secret string) string {
+	nameplate, _, _ := splitSecret(secret)
+	if wrong := nameplate + "-wrong-guess"; wrong != secret {
+		return wrong
+	}
+	return nameplate + "-other-guess"
+}
+
+// flakyFile fails once partway through, as if the sender's connection dropped.
+type flakyFile struct {
+	*bytes.Reader
+	failAt int64
+}
+
+func (f *flakyFile) Read(p []byte) (int, error) {
+	pos := f.Size() - int64(f.Len())
+	if f.failAt > 0 && pos+int64(len(p)) > f.failAt {
+		if pos >= f.failAt {
+			f.failAt = 0
+			return 0, errors.New("connection dropped")
+		}
+		p = p[:f.failAt-pos]
+	}
+	return f.Reader.Read(p)
+}
+
+func (f *flakyFile) Close() error {
+	return nil
+}
+
+func TestClientResume(t *testing.T) {
+	contents := make([]byte, 3*chunkSize+100)
+	for i := range contents {
+		contents[i] = byte(i)
+	}
+
+	server := httptest.NewServer(NewHandler(newSecretList("some-secret-string"), ioutil.Discard))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	sender := NewClient(u.Host)
+	receiver := NewClient(u.Host)
+
+	var sentDigest []byte
+	file := &flakyFile{Reader: bytes.NewReader(contents), failAt: 2*chunkSize + 500}
+	secret, send, err := sender.Offer("file.txt", file, WithDigestFunc(func(sum []byte) {
+		sentDigest = sum
+	}))
+	if err != nil {
+		t.Fatal("client.Offer:", err)
+	}
+
+	sent := make(chan error)
+	go func() {
+		sent <- send(context.Background())
+	}()
+
+	_, stream, err := receiver.Receive(secret)
+	if err != nil {
+		t.Fatal("client.Receive:", err)
+	}
+	received, err := ioutil.ReadAll(stream)
+
+	t.Run("receiver resumes", func(t *testing.T) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(received, contents) {
+			t.Errorf("got %v bytes, want %v", len(received), len(contents))
+		}
+	})
+
+	t.Run("sender resumes", func(t *testing.T) {
+		if err := <-sent; err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("digests match", func(t *testing.T) {
+		want := sha256.Sum256(contents)
+
+		if !bytes.Equal(sentDigest, want[:]) {
+			t.Errorf("sent %x, want %x", sentDigest, want)
+		}
+		if got := stream.(Digester).Digest(); !bytes.Equal(got, want[:]) {
+			t.Errorf("received %x, want %x", got, want)
+		}
+	})
+}
+
+func TestClientAbortByReceiver(t *testing.T) {
+	server := httptest.NewServer(NewHandler(newSecretList("some-secret-string"), ioutil.Discard))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	sender := NewClient(u.Host)
+	receiver := NewClient(u.Host)
+
+	file := ioutil.NopCloser(&genReader{remaining: 1 << 40})
+	secret, send, err := sender.Offer("endless.txt", file)
+	if err != nil {
+		t.Fatal("client.Offer:", err)
+	}
+	sent := make(chan error, 1)
+	go func() {
+		sent <- send(context.Background())
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, stream, err := receiver.ReceiveContext(ctx, secret)
+	if err != nil {
+		t.Fatal("client.ReceiveContext:", err)
+	}
+	if _, err := io.CopyN(ioutil.Discard, stream, 1<<20); err != nil {
+		t.Fatal("reading stream:", err)
+	}
+	cancel()
+	_, err = ioutil.ReadAll(stream)
+
+	t.Run("fails the receiver", func(t *testing.T) {
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v, want %v", err, context.Canceled)
+		}
+	})
+
+	t.Run("aborts the sender", func(t *testing.T) {
+		select {
+		case err := <-sent:
+			if err == nil {
+				t.Error("got nil, want an error")
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("sender is still sending")
+		}
+	})
+}
+
+func TestClientAbortBySender(t *testing.T) {
+	server := httptest.NewServer(NewHandler(newSecretList("some-secret-string"), ioutil.Discard))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	sender := NewClient(u.Host)
+	receiver := NewClient(u.Host)
+
+	file := ioutil.NopCloser(&genReader{remaining: 1 << 40})
+	secret, send, err := sender.Offer("endless.txt", file)
+	if err != nil {
+		t.Fatal("client.Offer:", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sent := make(chan error, 1)
+	go func() {
+		sent <- send(ctx)
+	}()
+
+	_, stream, err := receiver.Receive(secret)
+	if err != nil {
+		t.Fatal("client.Receive:", err)
+	}
+	if _, err := io.CopyN(ioutil.Discard, stream, 1<<20); err != nil {
+		t.Fatal("reading stream:", err)
+	}
+	cancel()
+
+	t.Run("fails the sender", func(t *testing.T) {
+		if err := <-sent; !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v, want %v", err, context.Canceled)
+		}
+	})
+
+	t.Run("aborts the receiver", func(t *testing.T) {
+		received := make(chan error, 1)
+		go func() {
+			_, err := ioutil.ReadAll(stream)
+			received <- err
+		}()
+
+		select {
+		case err := <-received:
+			if err == nil {
+				t.Error("got nil, want an error")
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("receiver is still receiving")
+		}
+	})
+}
+
+func TestClientDecline(t *testing.T) {
+	server := httptest.NewServer(NewHandler(newSecretList("some-secret-string"), ioutil.Discard))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	secret, send, err := NewClient(u.Host).Offer("a.txt", ioutil.NopCloser(strings.NewReader("unwanted")), WithSize(8), WithNote("hello"))
+	if err != nil {
+		t.Fatal("client.Offer:", err)
+	}
+	sent := make(chan error, 1)
+	go func() {
+		sent <- send(context.Background())
+	}()
+
+	t.Run("hides the metadata from receivers with the wrong code", func(t *testing.T) {
+		nameplate, _, _ := splitSecret(secret)
+		_, _, err := NewClient(u.Host).Receive(nameplate+"-wrong-password", WithConfirm(func(meta Metadata) bool {
+			t.Errorf("asked to confirm %+v", meta)
+			return false
+		}))
+		if !errors.Is(err, ErrWrongSecret) {
+			t.Errorf("got %v, want %v", err, ErrWrongSecret)
+		}
+	})
+
+	var seen Metadata
+	_, stream, err := NewClient(u.Host).Receive(secret, WithConfirm(func(meta Metadata) bool {
+		seen = meta
+		return false
+	}))
+
+	t.Run("shows the receiver the metadata", func(t *testing.T) {
+		if seen.Filename != "a.txt" || seen.Size != 8 || seen.Note != "hello" {
+			t.Errorf("got %+v", seen)
+		}
+	})
+
+	t.Run("fails the receiver", func(t *testing.T) {
+		if !errors.Is(err, ErrDeclined) || stream != nil {
+			t.Errorf("got %v and %v, want %v", stream, err, ErrDeclined)
+		}
+	})
+
+	t.Run("fails the sender", func(t *testing.T) {
+		select {
+		case err := <-sent:
+			if !errors.Is(err, ErrDeclined) {
+				t.Errorf("got %v, want %v", err, ErrDeclined)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("sender is still waiting")
+		}
+	})
+
+	t.Run("consumes the offer", func(t *testing.T) {
+		if _, _, err := NewClient(u.Host).Receive(secret); err == nil {
+			t.Error("received a declined offer")
+		}
+	})
+}
+
+func TestClientConfirm(t *testing.T) {
+	server := httptest.NewServer(NewHandler(newSecretList("some-secret-string"), ioutil.Discard))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	secret, send, err := NewClient(u.Host).Offer("a.txt", ioutil.NopCloser(strings.NewReader("wanted")), WithSize(6))
+	if err != nil {
+		t.Fatal("client.Offer:", err)
+	}
+	sent := make(chan error, 1)
+	go func() {
+		sent <- send(context.Background())
+	}()
+
+	_, stream, err := NewClient(u.Host).Receive(secret, WithConfirm(func(meta Metadata) bool {
+		return meta.Filename == "a.txt"
+	}))
+	if err != nil {
+		t.Fatal("client.Receive:", err)
+	}
+	received, err := ioutil.ReadAll(stream)
+	stream.Close()
+
+	t.Run("streams the accepted offer", func(t *testing.T) {
+		if err != nil || string(received) != "wanted" {
+			t.Errorf("got %q and %v, want %q", received, err, "wanted")
+		}
+	})
+
+	t.Run("completes the sender", func(t *testing.T) {
+		if err := <-sent; err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestClientPasswords(t *testing.T) {
+	server := httptest.NewServer(NewHandler(newSecretList("some-secret-string"), ioutil.Discard))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	spanish, _ := Passwords("es")
+	secret, send, err := NewClient(u.Host, WithPasswords(spanish)).Offer("a.txt", ioutil.NopCloser(strings.NewReader("hola")))
+	if err != nil {
+		t.Fatal("client.Offer:", err)
+	}
+	go send(context.Background())
+
+	t.Run("draws words from the lists", func(t *testing.T) {
+		_, password, _ := splitSecret(secret)
+		words := strings.Split(password, "-")
+		if !contains(spanish.First, words[0]) || !contains(spanish.Second, words[1]) {
+			t.Errorf("got %q", password)
+		}
+	})
+
+	t.Run("receives", func(t *testing.T) {
+		_, stream, err := NewClient(u.Host).Receive(secret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stream.Close()
+		if got, _ := ioutil.ReadAll(stream); string(got) != "hola" {
+			t.Errorf("got %q, want %q", got, "hola")
+		}
+	})
+}
+
+func TestClientProgress(t *testing.T) {
+	contents := bytes.Repeat([]byte("x"), 3*chunkSize+100)
+
+	server := httptest.NewServer(NewHandler(newSecretList("some-secret-string"), ioutil.Discard))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	sender := NewClient(u.Host)
+	receiver := NewClient(u.Host)
+
+	var sent, received Progress
+	file := ioutil.NopCloser(bytes.NewReader(contents))
+	secret, send, err := sender.Offer("file.txt", file, WithSize(int64(len(contents))), WithSendProgress(func(p Progress) {
+		sent = p
+	}))
+	if err != nil {
+		t.Fatal("client.Offer:", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- send(context.Background())
+	}()
+
+	meta, stream, err := receiver.Receive(secret, WithReceiveProgress(func(p Progress) {
+		received = p
+	}))
+	if err != nil {
+		t.Fatal("client.Receive:", err)
+	}
+	if _, err := ioutil.ReadAll(stream); err != nil {
+		t.Fatal("reading stream:", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal("sending:", err)
+	}
+
+	want := int64(len(contents))
+
+	t.Run("advertises the size", func(t *testing.T) {
+		if meta.Size != want {
+			t.Errorf("got %v, want %v", meta.Size, want)
+		}
+	})
+
+	t.Run("reports sending", func(t *testing.T) {
+		if sent.Done != want || sent.Total != want {
+			t.Errorf("got %v/%v, want %v/%v", sent.Done, sent.Total, want, want)
+		}
+	})
+
+	t.Run("reports receiving", func(t *testing.T) {
+		if received.Done != want || received.Total != want {
+			t.Errorf("got %v/%v, want %v/%v", received.Done, received.Total, want, want)
+		}
+	})
+}
+
+func TestClientMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storj-metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "script.sh")
+	if err := ioutil.WriteFile(path, []byte("echo hi"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2001, 2, 3, 4, 5, 6, 7, time.UTC)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(NewHandler(newSecretList("some-secret-string"), ioutil.Discard))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	secret, send, err := NewClient(u.Host).Offer("script.sh", file, WithContentType("text/x-sh"), WithNote("run me\non the build server"))
+	if err != nil {
+		t.Fatal("client.Offer:", err)
+	}
+	go send(context.Background())
+
+	meta, stream, err := NewClient(u.Host).Receive(secret)
+	if err != nil {
+		t.Fatal("client.Receive:", err)
+	}
+	ioutil.ReadAll(stream)
+
+	want := Metadata{
+		Filename:    "script.sh",
+		ContentType: "text/x-sh",
+		Size:        7,
+		ModTime:     modTime,
+		Mode:        0750,
+		Note:        "run me\non the build server",
+	}
+	if !meta.ModTime.Equal(want.ModTime) {
+		t.Errorf("got mod time %v, want %v", meta.ModTime, want.ModTime)
+	}
+	meta.ModTime = want.ModTime
+	if meta != want {
+		t.Errorf("got %+v, want %+v", meta, want)
+	}
+}
+
+func TestClientCancel(t *testing.T) {
+	server := httptest.NewServer(NewHandler(newSecretList("some-secret-string"), ioutil.Discard))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	sender := NewClient(u.Host)
+	receiver := NewClient(u.Host)
+
+	secret, send, err := sender.Offer("filename.txt", ioutil.NopCloser(strings.NewReader("file contents")))
+	if err != nil {
+		t.Fatal("client.Offer:", err)
+	}
+	sent := make(chan error, 1)
+	go func() {
+		sent <- send(context.Background())
+	}()
+
+	t.Run("only the sender can cancel", func(t *testing.T) {
+		if err := receiver.Cancel(secret); err == nil {
+			t.Error("got nil, want an error")
+		}
+
+		nameplate, _, _ := splitSecret(secret)
+		request, _ := http.NewRequest(http.MethodDelete, server.URL+"/file/"+nameplate, nil)
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got, want := resp.StatusCode, http.StatusNotFound; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	if err := sender.Cancel(secret); err != nil {
+		t.Fatal("client.Cancel:", err)
+	}
+
+	t.Run("fails the sender", func(t *testing.T) {
+		select {
+		case err := <-sent:
+			if err == nil {
+				t.Error("got nil, want an error")
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("sender is still waiting")
+		}
+	})
+
+	t.Run("invalidates the secret", func(t *testing.T) {
+		if _, _, err := receiver.Receive(secret); err == nil {
+			t.Error("got nil, want an error")
+		}
+	})
+}
+
+func TestClientExpiry(t *testing.T) {
+	server := httptest.NewServer(NewHandler(newSecretList("some-secret-string"), ioutil.Discard))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	client := NewClient(u.Host)
+
+	var expires time.Time
+	_, _, err := client.Offer("filename.txt", ioutil.NopCloser(strings.NewReader("file contents")),
+		WithExpiry(90*time.Second), WithExpiresFunc(func(t time.Time) { expires = t }))
+	if err != nil {
+		t.Fatal("client.Offer:", err)
+	}
+
+	got := time.Until(expires).Round(30 * time.Second)
+	want := 90 * time.Second
+	if got != want {
+		t.Errorf("got expiry in %v, want %v", got, want)
+	}
+}
+
+func TestClientStall(t *testing.T) {
+	handler := NewHandler(newSecretList("some-secret-string"), ioutil.Discard, WithStallLimits(StallLimits{Idle: 200 * time.Millisecond}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	sender := NewClient(u.Host)
+	receiver := NewClient(u.Host)
+
+	release := make(chan struct{})
+	defer close(release)
+	file := ioutil.NopCloser(&stallReader{genReader{remaining: 3 * chunkSize}, release})
+	secret, send, err := sender.Offer("stalled.txt", file)
+	if err != nil {
+		t.Fatal("client.Offer:", err)
+	}
+	sent := make(chan error, 1)
+	go func() {
+		sent <- send(context.Background())
+	}()
+
+	_, stream, err := receiver.Receive(secret)
+	if err != nil {
+		t.Fatal("client.Receive:", err)
+	}
+
+	t.Run("fails the receiver", func(t *testing.T) {
+		if _, err := ioutil.ReadAll(stream); !errors.Is(err, ErrStalled) {
+			t.Errorf("got %v, want %v", err, ErrStalled)
+		}
+	})
+
+	t.Run("fails the sender", func(t *testing.T) {
+		select {
+		case err := <-sent:
+			if !errors.Is(err, ErrStalled) {
+				t.Errorf("got %v, want %v", err, ErrStalled)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("sender is still sending")
+		}
+	})
+}
+
+func TestClientQuotas(t *testing.T) {
+	handler := NewHandler(newSecretList("a-a-a", "b-b-b"), ioutil.Discard, WithQuotas(Quotas{OffersPerIP: 1, MaxSize: 1000}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	sender := NewClient(u.Host)
+
+	t.Run("too large", func(t *testing.T) {
+		_, _, err := sender.Offer("large.txt", ioutil.NopCloser(strings.NewReader("")), WithSize(1001))
+		if !errors.Is(err, ErrTooLarge) {
+			t.Errorf("got %v, want %v", err, ErrTooLarge)
+		}
+	})
+
+	t.Run("too many offers", func(t *testing.T) {
+		if _, _, err := sender.Offer("first.txt", ioutil.NopCloser(strings.NewReader(""))); err != nil {
+			t.Fatal("client.Offer:", err)
+		}
+		_, _, err := sender.Offer("second.txt", ioutil.NopCloser(strings.NewReader("")))
+		if !errors.Is(err, ErrTooManyRequests) {
+			t.Errorf("got %v, want %v", err, ErrTooManyRequests)
+		}
+		if err == nil || !strings.Contains(err.Error(), errTooManyOffers.Error()) {
+			t.Errorf("got %v, want the relay's reason", err)
+		}
+	})
 }
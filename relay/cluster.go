@@ -0,0 +1,72 @@
+package relay
+
+import (
+	"crypto/hmac"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+const (
+	nodeKeyHeader      = "relay-node-key"
+	forwardedForHeader = "relay-forwarded-for"
+)
+
+// Cluster lets several relay nodes, for instance behind a load balancer, share offers.
+//
+// A sender's connections must all reach the node that created its offer,
+// but any node can serve its receiver, by forwarding the receiver's requests to that node.
+type Cluster struct {
+	// Store records which node holds each offer, and must be shared by every node.
+	Store OfferStore
+	// Self is the URL at which the other nodes reach this one, such as "http://10.0.0.1:9021".
+	Self string
+	// Key authenticates requests forwarded between nodes, so that clients can't forge their addresses.
+	// It must be the same for every node, and kept secret from clients.
+	Key string
+	// Transport forwards requests to other nodes. If nil, http.DefaultTransport is used.
+	Transport http.RoundTripper
+}
+
+// forward proxies a request for an offer held by another node, which sees the original client's address.
+func (h *Handler) forward(w http.ResponseWriter, r *http.Request, node string) {
+	target, err := url.Parse(node)
+	if err != nil {
+		h.logger.Log(LevelError, "forwarding failed", "node", node, "error", err)
+		h.metrics.error(causeForward)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			req.Header.Set(nodeKeyHeader, h.cluster.Key)
+			req.Header.Set(forwardedForHeader, r.RemoteAddr)
+		},
+		Transport:     h.cluster.Transport,
+		FlushInterval: -1, // stream without buffering
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			h.logger.Log(LevelError, "forwarding failed", "node", node, "error", err)
+			h.metrics.error(causeForward)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	h.logger.Log(LevelDebug, "request forwarded", "node", node, "client", r.RemoteAddr)
+	proxy.ServeHTTP(w, r)
+}
+
+// forwarded reports whether r was forwarded by another node in the cluster,
+// returning a copy that carries the original client's address.
+func (h *Handler) forwarded(r *http.Request) (*http.Request, bool) {
+	key := r.Header.Get(nodeKeyHeader)
+	if key == "" || h.cluster.Key == "" || !hmac.Equal([]byte(key), []byte(h.cluster.Key)) {
+		return r, false
+	}
+
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.RemoteAddr = r.Header.Get(forwardedForHeader)
+	return r2, true
+}
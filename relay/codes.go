@@ -0,0 +1,172 @@
+package relay
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PasswordWords are the two lists that a sender's password draws its first and second words from.
+// The lists share no words, so that a swapped or dropped word is noticed rather than silently failing
+// the key exchange.
+type PasswordWords struct {
+	First, Second []string
+}
+
+// englishPasswords are the default, adapted from the PGP word list.
+var englishPasswords = PasswordWords{First: oddWords, Second: evenWords}
+
+// Passwords returns the password words for a language, by its ISO 639-1 code (eg, "es"), for use with
+// WithPasswords. English uses words adapted from the PGP word list; other languages split their built-in
+// Wordlist between the two positions, alternating word by word, so their passwords have fewer combinations.
+// It reports false if there's no list for the language.
+func Passwords(lang string) (PasswordWords, bool) {
+	if strings.ToLower(lang) == "en" {
+		return englishPasswords, true
+	}
+	list, ok := Wordlist(lang)
+	if !ok {
+		return PasswordWords{}, false
+	}
+	var words PasswordWords
+	for i, word := range list {
+		if i%2 == 0 {
+			words.First = append(words.First, word)
+		} else {
+			words.Second = append(words.Second, word)
+		}
+	}
+	return words, true
+}
+
+// newPassword returns a random password of a first word followed by a second word.
+func (p PasswordWords) newPassword(rng *rand.Rand) string {
+	return p.First[rng.Intn(len(p.First))] + "-" + p.Second[rng.Intn(len(p.Second))]
+}
+
+// CorrectSecret is PasswordWords.Correct with the default, English password words.
+func CorrectSecret(secret string) (string, bool) {
+	return englishPasswords.Correct(secret)
+}
+
+// CompleteWord is PasswordWords.Complete with the default, English password words.
+func CompleteWord(partial string) []string {
+	return englishPasswords.Complete(partial)
+}
+
+// Correct checks the password words at the end of a secret against the lists they're drawn from,
+// returning the secret with each misspelled word replaced by the nearest valid one, by edit distance,
+// and whether any were. Words typed in the wrong order are swapped back.
+//
+// The secret is also normalized from what a person might type: lowercased, with words separated by hyphens
+// rather than spaces. The relay's part of the secret isn't corrected, since only the relay knows its words.
+func (p PasswordWords) Correct(secret string) (string, bool) {
+	parts := strings.FieldsFunc(strings.ToLower(secret), func(r rune) bool {
+		return r == '-' || r == ' ' || r == '\t'
+	})
+	if len(parts) <= passwordWords {
+		return strings.Join(parts, "-"), false
+	}
+
+	typed := strings.Join(parts, "-")
+	first, second := parts[len(parts)-2], parts[len(parts)-1]
+	if contains(p.Second, first) && contains(p.First, second) {
+		first, second = second, first
+	}
+	parts[len(parts)-2] = nearest(p.First, first)
+	parts[len(parts)-1] = nearest(p.Second, second)
+
+	corrected := strings.Join(parts, "-")
+	return corrected, corrected != typed
+}
+
+// Complete returns the words that could complete the last word of a partly typed secret,
+// in alphabetical order. A secret that begins with a number is taken to be "<number>-<word>-<word>",
+// so its words are completed from the list for their position; otherwise any password word may match.
+func (p PasswordWords) Complete(partial string) []string {
+	parts := strings.Split(strings.ToLower(partial), "-")
+	prefix := parts[len(parts)-1]
+
+	lists := [][]string{p.First, p.Second}
+	if _, err := strconv.Atoi(parts[0]); err == nil {
+		switch len(parts) {
+		case 2:
+			lists = [][]string{p.First}
+		case 3:
+			lists = [][]string{p.Second}
+		default:
+			return nil
+		}
+	}
+
+	var matches []string
+	for _, list := range lists {
+		for _, word := range list {
+			if strings.HasPrefix(word, prefix) {
+				matches = append(matches, word)
+			}
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// nearest returns the word in list with the smallest edit distance to word, preferring the earliest on ties.
+func nearest(list []string, word string) string {
+	best, min := word, -1
+	for _, candidate := range list {
+		d := editDistance(word, candidate)
+		if d == 0 {
+			return candidate
+		}
+		if min < 0 || d < min {
+			best, min = candidate, d
+		}
+	}
+	return best
+}
+
+func contains(list []string, word string) bool {
+	for _, w := range list {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
+
+// editDistance counts the insertions, deletions, substitutions and transpositions of adjacent letters
+// that turn a into b (the optimal string alignment distance).
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	// rows i-2, i-1 and i of the distance matrix
+	prev2, prev, cur := make([]int, len(rb)+1), make([]int, len(rb)+1), make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = minInt(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
+
+func minInt(first int, rest ...int) int {
+	for _, n := range rest {
+		if n < first {
+			first = n
+		}
+	}
+	return first
+}
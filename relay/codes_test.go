@@ -0,0 +1,124 @@
+package relay
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewPassword(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		words := strings.Split(englishPasswords.newPassword(rng), "-")
+		if len(words) != passwordWords || !contains(oddWords, words[0]) || !contains(evenWords, words[1]) {
+			t.Fatalf("got %q, want an odd word followed by an even word", words)
+		}
+	}
+}
+
+func TestPasswords(t *testing.T) {
+	for _, lang := range Languages() {
+		t.Run(lang, func(t *testing.T) {
+			words, ok := Passwords(lang)
+			if !ok {
+				t.Fatal("no password words")
+			}
+			if len(words.First) == 0 || len(words.Second) == 0 {
+				t.Fatalf("got %v and %v words", len(words.First), len(words.Second))
+			}
+			for _, word := range words.First {
+				if contains(words.Second, word) {
+					t.Errorf("%q is in both lists", word)
+				}
+			}
+		})
+	}
+
+	if _, ok := Passwords("xx"); ok {
+		t.Error("got password words for an unknown language")
+	}
+
+	spanish, _ := Passwords("es")
+	tests := []struct {
+		secret string
+		want   string
+	}{
+		{"7-abuleo-agua", "7-abuelo-agua"},
+		{"7-agua-abajo", "7-abajo-agua"},
+	}
+	for _, test := range tests {
+		if got, _ := spanish.Correct(test.secret); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+	if got, want := spanish.Complete("7-abr"), []string{"abrazo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCorrectSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		want    string
+		changed bool
+	}{
+		{"correct", "7-guitarist-revenge", "7-guitarist-revenge", false},
+		{"substitution", "7-guitarist-revenje", "7-guitarist-revenge", true},
+		{"deletion", "7-gitarist-revenge", "7-guitarist-revenge", true},
+		{"insertion", "7-guitarrist-revenge", "7-guitarist-revenge", true},
+		{"transposition", "7-guitarist-revegne", "7-guitarist-revenge", true},
+		{"swapped words", "7-revenge-guitarist", "7-guitarist-revenge", true},
+		{"spaces and capitals", "7 Guitarist Revenge", "7-guitarist-revenge", false},
+		{"word nameplates", "little-earth-music-guitarist-revenge", "little-earth-music-guitarist-revenge", false},
+		{"too short", "guitarist-revenge", "guitarist-revenge", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, changed := CorrectSecret(test.secret)
+			if got != test.want || changed != test.changed {
+				t.Errorf("got %q, %v, want %q, %v", got, changed, test.want, test.changed)
+			}
+		})
+	}
+}
+
+func TestCompleteWord(t *testing.T) {
+	tests := []struct {
+		partial string
+		want    []string
+	}{
+		{"7-guit", []string{"guitarist"}},
+		{"7-guitarist-rev", []string{"revenge"}},
+		{"7-re", []string{"rebellion", "recipe", "recover", "repellent", "replica", "reproduce", "resistor",
+			"responsive", "retraction", "retrieval", "retrospect", "revenue", "revival", "revolver"}},
+		{"7-guitarist-revenge-", nil},
+		{"little-earth-music-rev", []string{"revenge", "revenue", "revival", "revolver"}},
+	}
+	for _, test := range tests {
+		t.Run(test.partial, func(t *testing.T) {
+			if got := CompleteWord(test.partial); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"kitten", "sitting", 3},
+		{"revenge", "revegne", 1},
+		{"ca", "abc", 3},
+	}
+	for _, test := range tests {
+		if got := editDistance(test.a, test.b); got != test.want {
+			t.Errorf("editDistance(%q, %q) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
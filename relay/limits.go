@@ -0,0 +1,111 @@
+package relay
+
+import (
+	"sync"
+	"time"
+)
+
+// Limits protect offers from having their secrets guessed by brute force.
+type Limits struct {
+	// LookupFailures is the number of failed attempts a client IP may make within FailureWindow,
+	// by looking up secrets that don't exist or using the wrong code for one that does,
+	// before it's locked out of every offer for Lockout. Zero disables the limit.
+	LookupFailures int
+	FailureWindow  time.Duration
+	Lockout        time.Duration
+
+	// WrongCodes is the number of receivers the sender may reject for using the wrong code
+	// before its offer is destroyed. Zero disables the limit.
+	WrongCodes int
+
+	// ClaimTimeout is how long a receiver that has claimed an offer has to join it.
+	// Claims count toward WrongCodes while they're outstanding, so those that are never redeemed expire,
+	// and count as a failed attempt by the client that made them. Zero lets claims wait forever.
+	ClaimTimeout time.Duration
+}
+
+// DefaultLimits are enforced by a Handler unless it's created WithLimits.
+var DefaultLimits = Limits{
+	LookupFailures: 10,
+	FailureWindow:  time.Minute,
+	Lockout:        10 * time.Minute,
+	WrongCodes:     3,
+	ClaimTimeout:   30 * time.Second,
+}
+
+// limiter counts failed attempts by client IP.
+type limiter struct {
+	limits Limits
+	now    func() time.Time
+
+	sync.Mutex
+	clients map[string]*failures
+	swept   time.Time
+}
+
+type failures struct {
+	count  int
+	since  time.Time
+	locked time.Time
+}
+
+func newLimiter(limits Limits) *limiter {
+	return &limiter{
+		limits:  limits,
+		now:     time.Now,
+		clients: make(map[string]*failures),
+	}
+}
+
+// locked reports whether the client is currently locked out.
+func (l *limiter) locked(client string) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	f, ok := l.clients[client]
+	return ok && l.now().Before(f.locked)
+}
+
+// fail records a failed attempt by the client, reporting whether it has just been locked out.
+func (l *limiter) fail(client string) bool {
+	if l.limits.LookupFailures <= 0 {
+		return false
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	f, ok := l.clients[client]
+	if !ok {
+		f = &failures{since: now}
+		l.clients[client] = f
+	}
+	if now.Sub(f.since) > l.limits.FailureWindow {
+		// start a fresh window, without lifting a lockout that's still in force
+		f.count, f.since = 0, now
+	}
+	f.count++
+	if f.count < l.limits.LookupFailures {
+		return false
+	}
+	f.count = 0
+	f.since = now
+	f.locked = now.Add(l.limits.Lockout)
+	return true
+}
+
+// sweep forgets clients whose failures have aged out, at most once per window.
+func (l *limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < l.limits.FailureWindow {
+		return
+	}
+	l.swept = now
+	for client, f := range l.clients {
+		if now.Sub(f.since) > l.limits.FailureWindow && now.After(f.locked) {
+			delete(l.clients, client)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package relay
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := newLimiter(Limits{LookupFailures: 2, FailureWindow: time.Minute, Lockout: 10 * time.Minute})
+	l.now = func() time.Time { return now }
+
+	t.Run("forgets failures outside the window", func(t *testing.T) {
+		l.fail("a")
+		now = now.Add(2 * time.Minute)
+		if l.fail("a") {
+			t.Error("locked out, want a fresh window")
+		}
+	})
+
+	t.Run("locks out after too many failures", func(t *testing.T) {
+		if !l.fail("a") {
+			t.Error("not locked out")
+		}
+		if !l.locked("a") {
+			t.Error("not locked")
+		}
+		if l.locked("b") {
+			t.Error("locked another client")
+		}
+	})
+
+	t.Run("stays locked out through later failures", func(t *testing.T) {
+		now = now.Add(2 * time.Minute)
+		l.fail("a")
+		if !l.locked("a") {
+			t.Error("a failure in a fresh window lifted the lockout")
+		}
+	})
+
+	t.Run("unlocks after the lockout", func(t *testing.T) {
+		now = now.Add(8*time.Minute + time.Second)
+		if l.locked("a") {
+			t.Error("still locked")
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a logged event.
+type Level int
+
+// Levels, from the most verbose.
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the Level with a name such as "info".
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// Logger records the relay's events.
+//
+// Each event has a short name, such as "offer created", and is described by alternating keys and values.
+// Events in an offer's lifecycle carry its "transfer" ID, which is unrelated to its secret,
+// so logs can be correlated without ever revealing a code.
+type Logger interface {
+	Log(level Level, event string, keyvals ...interface{})
+}
+
+// NewLogfmtLogger returns a Logger that writes events at or above min to w, one logfmt line each.
+func NewLogfmtLogger(w io.Writer, min Level) Logger {
+	return &writerLogger{w: w, min: min, encode: encodeLogfmt, now: time.Now}
+}
+
+// NewJSONLogger returns a Logger that writes events at or above min to w, one JSON object per line.
+func NewJSONLogger(w io.Writer, min Level) Logger {
+	return &writerLogger{w: w, min: min, encode: encodeJSON, now: time.Now}
+}
+
+type writerLogger struct {
+	sync.Mutex
+	w      io.Writer
+	min    Level
+	encode func(buf *bytes.Buffer, keyvals []interface{})
+	now    func() time.Time
+}
+
+func (l *writerLogger) Log(level Level, event string, keyvals ...interface{}) {
+	if level < l.min {
+		return
+	}
+	if len(keyvals)%2 != 0 {
+		keyvals = append(keyvals, "(missing)")
+	}
+
+	all := append([]interface{}{"time", l.now().UTC(), "level", level.String(), "event", event}, keyvals...)
+	var buf bytes.Buffer
+	l.encode(&buf, all)
+	buf.WriteByte('\n')
+
+	l.Lock()
+	defer l.Unlock()
+	l.w.Write(buf.Bytes())
+}
+
+// logValue converts values to the form in which they're logged.
+func logValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case time.Duration:
+		return v.String()
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
+
+func encodeLogfmt(buf *bytes.Buffer, keyvals []interface{}) {
+	for i := 0; i < len(keyvals); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(fmt.Sprint(keyvals[i]))
+		buf.WriteByte('=')
+
+		s := fmt.Sprint(logValue(keyvals[i+1]))
+		if s == "" || strings.ContainsAny(s, " =\"\\") || strconv.Quote(s) != `"`+s+`"` {
+			s = strconv.Quote(s)
+		}
+		buf.WriteString(s)
+	}
+}
+
+func encodeJSON(buf *bytes.Buffer, keyvals []interface{}) {
+	buf.WriteByte('{')
+	for i := 0; i < len(keyvals); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(keyvals[i]))
+		buf.Write(key)
+		buf.WriteByte(':')
+
+		value, err := json.Marshal(logValue(keyvals[i+1]))
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(keyvals[i+1]))
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+}
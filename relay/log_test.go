@@ -0,0 +1,56 @@
+package relay
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoggers(t *testing.T) {
+	now := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		logger func(b *strings.Builder) Logger
+		want   string
+	}{
+		{
+			name:   "logfmt",
+			logger: func(b *strings.Builder) Logger { return NewLogfmtLogger(b, LevelInfo) },
+			want:   `time=2020-04-01T12:00:00Z level=warn event="transfer failed" transfer=abc bytes=42 error="broken pipe" took=1.5s` + "\n",
+		},
+		{
+			name:   "json",
+			logger: func(b *strings.Builder) Logger { return NewJSONLogger(b, LevelInfo) },
+			want:   `{"time":"2020-04-01T12:00:00Z","level":"warn","event":"transfer failed","transfer":"abc","bytes":42,"error":"broken pipe","took":"1.5s"}` + "\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var b strings.Builder
+			l := test.logger(&b)
+			l.(*writerLogger).now = func() time.Time { return now }
+
+			l.Log(LevelDebug, "ignored", "transfer", "abc")
+			l.Log(LevelWarn, "transfer failed", "transfer", "abc", "bytes", 42, "error", errors.New("broken pipe"), "took", 1500*time.Millisecond)
+
+			if got := b.String(); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, want := range []Level{LevelDebug, LevelInfo, LevelWarn, LevelError} {
+		got, err := ParseLevel(strings.ToUpper(want.String()))
+		if err != nil || got != want {
+			t.Errorf("got %v, %v, want %v", got, err, want)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("parsed an unknown level")
+	}
+}
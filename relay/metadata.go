@@ -0,0 +1,77 @@
+package relay
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	filenameHeader    = "suggested-filename"
+	contentTypeHeader = "offered-content-type"
+	sizeHeader        = "offered-size"
+	modTimeHeader     = "offered-mod-time"
+	modeHeader        = "offered-mode"
+	noteHeader        = "offered-note"
+)
+
+// Metadata describes an offered file.
+//
+// It's provided by the sender and should not be trusted without validation.
+type Metadata struct {
+	// Filename is suggested by the sender.
+	Filename string
+	// ContentType is the MIME type of the file, if the sender provided one.
+	ContentType string
+	// Size is the length of the file in bytes, or -1 if the sender didn't know it.
+	Size int64
+	// ModTime is when the file was last modified, or the zero time if unknown.
+	ModTime time.Time
+	// Mode holds the file's permission bits, or is 0 if unknown.
+	Mode os.FileMode
+	// Note is a message from the sender to the receiver, if it wrote one.
+	Note string
+}
+
+func (m Metadata) writeHeader(h http.Header) {
+	h.Set(filenameHeader, m.Filename)
+	if m.ContentType != "" {
+		h.Set(contentTypeHeader, m.ContentType)
+	}
+	if m.Size >= 0 {
+		h.Set(sizeHeader, strconv.FormatInt(m.Size, 10))
+	}
+	if !m.ModTime.IsZero() {
+		h.Set(modTimeHeader, m.ModTime.UTC().Format(time.RFC3339Nano))
+	}
+	if m.Mode != 0 {
+		h.Set(modeHeader, strconv.FormatUint(uint64(m.Mode.Perm()), 8))
+	}
+	if m.Note != "" {
+		// escaped, since header values can't hold line breaks
+		h.Set(noteHeader, url.PathEscape(m.Note))
+	}
+}
+
+func readMetadata(h http.Header) Metadata {
+	m := Metadata{
+		Filename:    h.Get(filenameHeader),
+		ContentType: h.Get(contentTypeHeader),
+		Size:        -1,
+	}
+	if size, err := strconv.ParseInt(h.Get(sizeHeader), 10, 64); err == nil && size >= 0 {
+		m.Size = size
+	}
+	if t, err := time.Parse(time.RFC3339Nano, h.Get(modTimeHeader)); err == nil {
+		m.ModTime = t
+	}
+	if mode, err := strconv.ParseUint(h.Get(modeHeader), 8, 32); err == nil {
+		m.Mode = os.FileMode(mode).Perm()
+	}
+	if note, err := url.PathUnescape(h.Get(noteHeader)); err == nil {
+		m.Note = note
+	}
+	return m
+}
@@ -0,0 +1,165 @@
+package relay
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Causes of the errors counted by the relay's metrics.
+const (
+	causeCreate   = "create"
+	causeTransfer = "transfer"
+	causeAbort    = "abort"
+	causeForward  = "forward"
+	causeStore    = "store"
+	causeStall    = "stall"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the transfer duration histogram.
+var durationBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600}
+
+// metrics counts the relay's activity, for exposition in the Prometheus text format.
+type metrics struct {
+	bytesRelayed int64 // accessed atomically, so first for alignment
+
+	sync.Mutex
+	inFlight      int
+	timeouts      int
+	failedLookups int
+	wrongCodes    int
+	declines      int
+	lockouts      int
+	refusals      int
+	errors        map[string]int
+	durations     []int // per bucket, plus +Inf
+	durationSum   float64
+	durationCount int
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		errors:    make(map[string]int),
+		durations: make([]int, len(durationBuckets)+1),
+	}
+}
+
+func (m *metrics) count(counter *int) {
+	m.Lock()
+	defer m.Unlock()
+	*counter++
+}
+
+func (m *metrics) error(cause string) {
+	m.Lock()
+	defer m.Unlock()
+	m.errors[cause]++
+}
+
+// transferring counts a transfer as in flight, until the returned func is called with whether it completed.
+func (m *metrics) transferring() (done func(completed bool)) {
+	start := time.Now()
+	m.count(&m.inFlight)
+
+	return func(completed bool) {
+		m.Lock()
+		defer m.Unlock()
+
+		m.inFlight--
+		if !completed {
+			return
+		}
+		seconds := time.Since(start).Seconds()
+		i := sort.SearchFloat64s(durationBuckets, seconds)
+		m.durations[i]++
+		m.durationSum += seconds
+		m.durationCount++
+	}
+}
+
+// countingWriter adds everything written through it to the bytes relayed, and to the offer's bytes moved.
+type countingWriter struct {
+	w     http.ResponseWriter
+	m     *metrics
+	moved *int64
+}
+
+func (cw countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	atomic.AddInt64(&cw.m.bytesRelayed, int64(n))
+	atomic.AddInt64(cw.moved, int64(n))
+	return n, err
+}
+
+// handleMetrics serves the relay's metrics in the Prometheus text format.
+func (h *Handler) handleMetrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		h.RLock()
+		active := len(h.offers)
+		h.RUnlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		h.metrics.write(w, active)
+	}
+}
+
+func (m *metrics) write(w io.Writer, activeOffers int) {
+	m.Lock()
+	defer m.Unlock()
+
+	metric(w, "relay_offers_active", "gauge", "Offers waiting for or in the middle of a transfer.")
+	fmt.Fprintf(w, "relay_offers_active %v\n", activeOffers)
+
+	metric(w, "relay_transfers_in_flight", "gauge", "Transfers currently streaming between a sender and receiver.")
+	fmt.Fprintf(w, "relay_transfers_in_flight %v\n", m.inFlight)
+
+	metric(w, "relay_bytes_relayed_total", "counter", "Bytes streamed from senders to receivers.")
+	fmt.Fprintf(w, "relay_bytes_relayed_total %v\n", atomic.LoadInt64(&m.bytesRelayed))
+
+	metric(w, "relay_transfer_duration_seconds", "histogram", "Time taken by completed transfers.")
+	cumulative := 0
+	for i, bound := range durationBuckets {
+		cumulative += m.durations[i]
+		fmt.Fprintf(w, "relay_transfer_duration_seconds_bucket{le=\"%v\"} %v\n", bound, cumulative)
+	}
+	cumulative += m.durations[len(durationBuckets)]
+	fmt.Fprintf(w, "relay_transfer_duration_seconds_bucket{le=\"+Inf\"} %v\n", cumulative)
+	fmt.Fprintf(w, "relay_transfer_duration_seconds_sum %v\n", m.durationSum)
+	fmt.Fprintf(w, "relay_transfer_duration_seconds_count %v\n", m.durationCount)
+
+	metric(w, "relay_offer_timeouts_total", "counter", "Offers that expired before completing.")
+	fmt.Fprintf(w, "relay_offer_timeouts_total %v\n", m.timeouts)
+
+	metric(w, "relay_wrong_secret_lookups_total", "counter", "Requests for secrets that don't exist.")
+	fmt.Fprintf(w, "relay_wrong_secret_lookups_total %v\n", m.failedLookups)
+
+	metric(w, "relay_wrong_codes_total", "counter", "Receivers rejected by a sender for using the wrong code.")
+	fmt.Fprintf(w, "relay_wrong_codes_total %v\n", m.wrongCodes)
+
+	metric(w, "relay_declined_offers_total", "counter", "Offers that receivers turned down.")
+	fmt.Fprintf(w, "relay_declined_offers_total %v\n", m.declines)
+
+	metric(w, "relay_lockouts_total", "counter", "Client IPs locked out after too many failures.")
+	fmt.Fprintf(w, "relay_lockouts_total %v\n", m.lockouts)
+	metric(w, "relay_quota_refusals_total", "counter", "Offers and receivers refused for exceeding a quota.")
+	fmt.Fprintf(w, "relay_quota_refusals_total %v\n", m.refusals)
+
+	metric(w, "relay_errors_total", "counter", "Errors, by cause.")
+	causes := []string{causeCreate, causeTransfer, causeAbort, causeForward, causeStore, causeStall}
+	for _, cause := range causes {
+		fmt.Fprintf(w, "relay_errors_total{cause=%q} %v\n", cause, m.errors[cause])
+	}
+}
+
+func metric(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, kind)
+}
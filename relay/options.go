@@ -0,0 +1,195 @@
+package relay
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"time"
+)
+
+// ClientOption configures a Client created by NewClient.
+type ClientOption func(*Client)
+
+// WithTLSConfig connects to the relay over HTTPS using config,
+// for instance to trust a private CA or to pin the relay's certificate with PinnedTLSConfig.
+func WithTLSConfig(config *tls.Config) ClientOption {
+	return func(c *Client) {
+		c.tlsConfig = config
+	}
+}
+
+// WithPasswords draws the words of offers' passwords from words, such as those for another language
+// from Passwords, rather than the English default. Receivers correct and complete the words they type
+// against the same lists. Lists that are empty are ignored.
+func WithPasswords(words PasswordWords) ClientOption {
+	return func(c *Client) {
+		if len(words.First) > 0 && len(words.Second) > 0 {
+			c.words = words
+		}
+	}
+}
+
+// OfferOption configures an offer made by Client.Offer.
+type OfferOption func(*offerOptions)
+
+type offerOptions struct {
+	meta       Metadata
+	digestFn   func(sum []byte)
+	progressFn ProgressFunc
+	expiresIn  time.Duration
+	expiresFn  func(expires time.Time)
+}
+
+// WithContentType offers the file with the given MIME type.
+func WithContentType(contentType string) OfferOption {
+	return func(o *offerOptions) {
+		o.meta.ContentType = contentType
+	}
+}
+
+// WithSize advertises the size of the file to the receiver.
+// It's unnecessary for an *os.File, whose size is found automatically.
+func WithSize(size int64) OfferOption {
+	return func(o *offerOptions) {
+		o.meta.Size = size
+	}
+}
+
+// WithFileInfo offers the size, modification time and permissions in info.
+// It's unnecessary for an *os.File, whose info is found automatically.
+func WithFileInfo(info os.FileInfo) OfferOption {
+	return func(o *offerOptions) {
+		o.meta.Size = info.Size()
+		o.meta.ModTime = info.ModTime()
+		o.meta.Mode = info.Mode().Perm()
+	}
+}
+
+// WithNote offers a note along with the file, for the receiver to see before accepting it.
+// Like the filename, it isn't encrypted, so the relay can read it.
+func WithNote(note string) OfferOption {
+	return func(o *offerOptions) {
+		o.meta.Note = note
+	}
+}
+
+// WithSendProgress calls fn as the file is sent.
+func WithSendProgress(fn ProgressFunc) OfferOption {
+	return func(o *offerOptions) {
+		o.progressFn = fn
+	}
+}
+
+// WithDigestFunc calls fn with the SHA-256 of the file once it has been sent.
+// The receiver verifies the same digest, and either side may display it for comparison out-of-band.
+func WithDigestFunc(fn func(sum []byte)) OfferOption {
+	return func(o *offerOptions) {
+		o.digestFn = fn
+	}
+}
+
+// WithExpiry asks the relay to keep the offer for d while it waits for a receiver,
+// rather than its default. The relay may cap d.
+func WithExpiry(d time.Duration) OfferOption {
+	return func(o *offerOptions) {
+		o.expiresIn = d
+	}
+}
+
+// WithExpiresFunc calls fn with the time the relay will destroy the offer if nobody receives it,
+// before Offer returns.
+func WithExpiresFunc(fn func(expires time.Time)) OfferOption {
+	return func(o *offerOptions) {
+		o.expiresFn = fn
+	}
+}
+
+// ReceiveOption configures a transfer received by Client.Receive.
+type ReceiveOption func(*receiveOptions)
+
+type receiveOptions struct {
+	progressFn ProgressFunc
+	confirmFn  func(meta Metadata) bool
+}
+
+// WithReceiveProgress calls fn as the file is received, with a total from the size the sender advertised.
+func WithReceiveProgress(fn ProgressFunc) ReceiveOption {
+	return func(o *receiveOptions) {
+		o.progressFn = fn
+	}
+}
+
+// WithConfirm calls fn with the offer's metadata, once the sender has accepted the receiver's key confirmation
+// but before anything is read, to ask whether to accept it.
+// If fn returns false, the offer is declined: the relay destroys it and the sender fails with ErrDeclined,
+// as does the receiver.
+func WithConfirm(fn func(meta Metadata) bool) ReceiveOption {
+	return func(o *receiveOptions) {
+		o.confirmFn = fn
+	}
+}
+
+// HandlerOption configures a Handler created by NewHandler.
+type HandlerOption func(*Handler)
+
+// WithLimits replaces DefaultLimits on failed lookups and wrong codes.
+func WithLimits(limits Limits) HandlerOption {
+	return func(h *Handler) {
+		h.limits = limits
+	}
+}
+
+// WithStallLimits replaces DefaultStallLimits on transfers that stop making progress.
+func WithStallLimits(limits StallLimits) HandlerOption {
+	return func(h *Handler) {
+		h.stalls = limits
+	}
+}
+
+// WithQuotas bounds the offers, transfers, file sizes and bandwidth that the Handler allows.
+// By default, none are bounded.
+func WithQuotas(quotas Quotas) HandlerOption {
+	return func(h *Handler) {
+		h.quotas = quotas
+	}
+}
+
+// WithCluster shares offers with other relay nodes, as configured by c.
+func WithCluster(c Cluster) HandlerOption {
+	return func(h *Handler) {
+		h.cluster = c
+	}
+}
+
+// WithLogger replaces the logfmt Logger writing to the Writer passed to NewHandler.
+func WithLogger(l Logger) HandlerOption {
+	return func(h *Handler) {
+		h.logger = l
+	}
+}
+
+// WithTrustedProxies trusts the Forwarded and X-Forwarded-For headers of requests from these networks,
+// such as a load balancer's, to identify clients for logging and rate limiting.
+// Requests from anywhere else are identified by the address they connect from.
+func WithTrustedProxies(networks ...*net.IPNet) HandlerOption {
+	return func(h *Handler) {
+		h.proxies = networks
+	}
+}
+
+// WithOfferLifetime sets how long offers wait for a receiver: lifetime unless their sender chooses otherwise
+// with WithExpiry, and never more than max. The defaults are 10 minutes and an hour.
+// Every offer expires eventually, so durations that aren't positive leave the defaults in place.
+func WithOfferLifetime(lifetime, max time.Duration) HandlerOption {
+	return func(h *Handler) {
+		if lifetime > 0 {
+			h.lifetime = lifetime
+		}
+		if max > 0 {
+			h.maxLife = max
+		}
+		if h.maxLife < h.lifetime {
+			h.maxLife = h.lifetime
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package relay
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Progress is a snapshot of a transfer in flight.
+type Progress struct {
+	// Done is the number of bytes of the file transferred so far.
+	Done int64
+	// Total is the size of the file, or -1 if it's unknown.
+	Total int64
+	// Rate is the average speed of the transfer so far, in bytes per second.
+	Rate float64
+	// ETA estimates the time remaining, or is 0 if it can't be estimated.
+	ETA time.Duration
+}
+
+// ProgressFunc is called with the progress of a transfer each time more of it is sent or received.
+type ProgressFunc func(Progress)
+
+// meter tracks a transfer's progress and reports it to a ProgressFunc.
+// A nil meter does nothing.
+type meter struct {
+	fn    ProgressFunc
+	total int64
+	now   func() time.Time
+
+	sync.Mutex
+	start time.Time
+	done  int64
+	moved int64 // every byte transferred, including any resent to resume
+}
+
+func newMeter(fn ProgressFunc, total int64) *meter {
+	if fn == nil {
+		return nil
+	}
+	return &meter{fn: fn, total: total, now: time.Now}
+}
+
+// add records n more bytes transferred.
+func (m *meter) add(n int) {
+	if m == nil || n == 0 {
+		return
+	}
+	m.Lock()
+	if m.start.IsZero() {
+		m.start = m.now()
+	}
+	m.done += int64(n)
+	m.moved += int64(n)
+	p := m.progress()
+	m.Unlock()
+
+	m.fn(p)
+}
+
+// rewind moves progress back to offset, to resume a transfer.
+func (m *meter) rewind(offset int64) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.done = offset
+}
+
+func (m *meter) progress() Progress {
+	p := Progress{Done: m.done, Total: m.total}
+	if elapsed := m.now().Sub(m.start).Seconds(); elapsed > 0 {
+		p.Rate = float64(m.moved) / elapsed
+	}
+	if p.Rate > 0 && p.Total >= p.Done {
+		p.ETA = time.Duration(float64(p.Total-p.Done) / p.Rate * float64(time.Second))
+	}
+	return p
+}
+
+// meteredReader counts what's read through it.
+type meteredReader struct {
+	r io.Reader
+	m *meter
+}
+
+func (mr meteredReader) Read(p []byte) (int, error) {
+	n, err := mr.r.Read(p)
+	mr.m.add(n)
+	return n, err
+}
@@ -0,0 +1,49 @@
+package relay
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMeter(t *testing.T) {
+	now := time.Now()
+	var got Progress
+	m := newMeter(func(p Progress) { got = p }, 1000)
+	m.now = func() time.Time { return now }
+
+	m.add(100)
+	now = now.Add(time.Second)
+	m.add(100)
+
+	t.Run("counts bytes", func(t *testing.T) {
+		if got.Done != 200 || got.Total != 1000 {
+			t.Errorf("got %v/%v, want 200/1000", got.Done, got.Total)
+		}
+	})
+
+	t.Run("measures rate", func(t *testing.T) {
+		if got.Rate != 200 {
+			t.Errorf("got %v, want 200", got.Rate)
+		}
+	})
+
+	t.Run("estimates time remaining", func(t *testing.T) {
+		if want := 4 * time.Second; got.ETA != want {
+			t.Errorf("got %v, want %v", got.ETA, want)
+		}
+	})
+
+	t.Run("rewinds to resume", func(t *testing.T) {
+		m.rewind(0)
+		m.add(50)
+		if got.Done != 50 {
+			t.Errorf("got %v, want 50", got.Done)
+		}
+	})
+
+	t.Run("reports nothing without a func", func(t *testing.T) {
+		var m *meter = newMeter(nil, 1000)
+		m.add(100)
+		m.rewind(0)
+	})
+}
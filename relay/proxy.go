@@ -0,0 +1,235 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyHeaderTimeout bounds how long a connection may take to send its PROXY protocol header.
+const proxyHeaderTimeout = 10 * time.Second
+
+// proxySignature begins every PROXY protocol v2 header.
+var proxySignature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var errProxyHeader = errors.New("invalid PROXY protocol header")
+
+// proxied returns r with its RemoteAddr replaced by the client's, if it came through trusted proxies.
+//
+// Forwarded (RFC 7239) is preferred to X-Forwarded-For. Either lists the addresses a request passed through,
+// so the client is the last address that isn't itself a trusted proxy.
+func (h *Handler) proxied(r *http.Request) *http.Request {
+	if len(h.proxies) == 0 || !h.trusted(clientIP(r.RemoteAddr)) {
+		return r
+	}
+
+	chain := forwardedFor(r.Header["Forwarded"])
+	if len(chain) == 0 {
+		for _, v := range r.Header["X-Forwarded-For"] {
+			for _, addr := range strings.Split(v, ",") {
+				chain = append(chain, strings.TrimSpace(addr))
+			}
+		}
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := net.ParseIP(chain[i])
+		if ip == nil {
+			return r // garbled or obfuscated, so it can't be trusted any further
+		}
+		if i > 0 && h.trusted(chain[i]) {
+			continue
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.RemoteAddr = ip.String()
+		return r2
+	}
+	return r
+}
+
+// trusted reports whether ip belongs to a trusted proxy.
+func (h *Handler) trusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range h.proxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the IPs in the "for" parameters of Forwarded headers, in order.
+func forwardedFor(values []string) []string {
+	var ips []string
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) != 2 || !strings.EqualFold(kv[0], "for") {
+					continue
+				}
+				node := strings.Trim(kv[1], `"`)
+				if host, _, err := net.SplitHostPort(node); err == nil {
+					node = host
+				}
+				ips = append(ips, strings.Trim(node, "[]"))
+			}
+		}
+	}
+	return ips
+}
+
+// NewProxyListener wraps l to accept connections that begin with an HAProxy PROXY protocol header,
+// version 1 or 2, so that their RemoteAddr is the client's rather than the proxy's.
+//
+// Every connection must carry a header, so l should only be reachable through the proxy.
+// Connections whose header is missing or malformed fail on their first read.
+func NewProxyListener(l net.Listener) net.Listener {
+	return proxyListener{l}
+}
+
+type proxyListener struct {
+	net.Listener
+}
+
+func (l proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// proxyConn reads its PROXY protocol header on first use,
+// so that a slow client doesn't hold up the listener.
+type proxyConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.remote, c.err = readProxyHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(p)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads a PROXY protocol header, returning the client's address,
+// or nil if the proxy didn't provide one (such as for its own health checks).
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	sig, err := r.Peek(len(proxySignature))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", errProxyHeader, err)
+	}
+	if bytes.Equal(sig, proxySignature) {
+		return readProxyHeaderV2(r)
+	}
+	if bytes.HasPrefix(sig, []byte("PROXY ")) {
+		return readProxyHeaderV1(r)
+	}
+	return nil, errProxyHeader
+}
+
+// readProxyHeaderV1 reads a header such as "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	const maxLength = 107
+
+	var line []byte
+	for len(line) < maxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", errProxyHeader, err)
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errProxyHeader
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, errProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyHeaderV2 reads a binary header.
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxySignature)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("%v: %w", errProxyHeader, err)
+	}
+	version, command := header[12]>>4, header[12]&0xf
+	family := header[13]
+	length := binary.BigEndian.Uint16(header[14:])
+	if version != 2 || command > 1 {
+		return nil, errProxyHeader
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("%v: %w", errProxyHeader, err)
+	}
+	if command == 0 {
+		return nil, nil // LOCAL: the proxy's own connection
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:]))}, nil
+	}
+	return nil, nil // other families carry no address we can use
+}
@@ -0,0 +1,125 @@
+package relay
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHandlerTrustedProxies(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	h := NewHandler(newSecretList("secret"), ioutil.Discard, WithTrustedProxies(proxies))
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"ignores headers from untrusted peers", "203.0.113.1:1234", map[string]string{"X-Forwarded-For": "192.0.2.1"}, "203.0.113.1:1234"},
+		{"uses X-Forwarded-For from trusted proxies", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "192.0.2.1"}, "192.0.2.1"},
+		{"skips trusted proxies in the chain", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.1, 192.0.2.1, 10.0.0.2"}, "192.0.2.1"},
+		{"prefers Forwarded", "10.0.0.1:1234", map[string]string{"Forwarded": `for="[2001:db8::1]:4711";proto=https, for=10.0.0.2`, "X-Forwarded-For": "192.0.2.1"}, "2001:db8::1"},
+		{"stops at garbled addresses", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "192.0.2.1, unknown"}, "10.0.0.1:1234"},
+		{"keeps the peer without headers", "10.0.0.1:1234", nil, "10.0.0.1:1234"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, "/file/secret", nil)
+			r.RemoteAddr = test.remote
+			for k, v := range test.headers {
+				r.Header.Set(k, v)
+			}
+
+			if got := h.proxied(r).RemoteAddr; got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	v2 := func(command, family byte, addrs []byte) string {
+		header := append([]byte{}, proxySignature...)
+		header = append(header, 0x20|command, family, 0, 0)
+		binary.BigEndian.PutUint16(header[14:], uint16(len(addrs)))
+		return string(append(header, addrs...))
+	}
+	ipv4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}
+
+	tests := []struct {
+		name   string
+		header string
+		want   string
+		err    bool
+	}{
+		{"v1 TCP4", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", "192.0.2.1:56324", false},
+		{"v1 TCP6", "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", "[2001:db8::1]:56324", false},
+		{"v1 UNKNOWN", "PROXY UNKNOWN\r\n", "", false},
+		{"v1 unterminated", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443", "", true},
+		{"v2 TCP4", v2(1, 0x11, ipv4), "192.0.2.1:56324", false},
+		{"v2 LOCAL", v2(0, 0, nil), "", false},
+		{"v2 truncated", v2(1, 0x11, ipv4[:4]), "", true},
+		{"no header", "GET / HTTP/1.1\r\n\r\n", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(test.header + "rest"))
+			addr, err := readProxyHeader(r)
+			if (err != nil) != test.err {
+				t.Fatalf("got error %v, want error: %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+
+			got := ""
+			if addr != nil {
+				got = addr.String()
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+			if rest, _ := ioutil.ReadAll(r); string(rest) != "rest" {
+				t.Errorf("got %q after the header, want %q", rest, "rest")
+			}
+		})
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l = NewProxyListener(l)
+
+	go func() {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nhello"))
+	}()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if got, want := conn.RemoteAddr().String(), "192.0.2.1:56324"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got, _ := ioutil.ReadAll(conn); string(got) != "hello" {
+		t.Errorf("got %q, want %q", got, "hello")
+	}
+}
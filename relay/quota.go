@@ -0,0 +1,133 @@
+package relay
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	errTooManyOffers    = errors.New("too many offers from this address")
+	errTooManyTransfers = errors.New("too many transfers in progress")
+	errTooLarge         = errors.New("file exceeds the relay's size limit")
+)
+
+// Quotas bound the resources that a Handler commits to its clients.
+// Zero disables any of them.
+type Quotas struct {
+	// OffersPerIP is how many offers a client IP may hold at once.
+	OffersPerIP int
+	// Transfers is how many receivers may be joined to offers at once, across every client.
+	Transfers int
+	// MaxSize is the largest file a sender may offer, in bytes.
+	// Files of unknown size are cut off once they exceed it.
+	MaxSize int64
+	// TransferRate caps the bandwidth of each transfer, in bytes per second.
+	TransferRate int64
+	// TotalRate caps the bandwidth of every transfer combined, in bytes per second.
+	TotalRate int64
+}
+
+// bucket is a token bucket that lets through rate bytes per second, in bursts of up to a second's worth.
+type bucket struct {
+	rate float64
+	now  func() time.Time
+
+	sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newBucket returns a bucket for rate bytes per second, or nil for an unlimited rate.
+func newBucket(rate int64) *bucket {
+	if rate <= 0 {
+		return nil
+	}
+	b := &bucket{rate: float64(rate), now: time.Now}
+	b.tokens, b.last = b.rate, b.now()
+	return b
+}
+
+// take spends n tokens, returning how long to wait before using them.
+// The bucket may go into debt, so callers wanting more than a burst are made to wait in proportion.
+func (b *bucket) take(n int) time.Duration {
+	b.Lock()
+	defer b.Unlock()
+
+	now := b.now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// throttledWriter paces writes to the rate of its buckets, until done is closed.
+type throttledWriter struct {
+	w       io.Writer
+	buckets []*bucket
+	done    <-chan struct{}
+}
+
+func (tw throttledWriter) Write(p []byte) (int, error) {
+	var wait time.Duration
+	for _, b := range tw.buckets {
+		if b == nil {
+			continue
+		}
+		if d := b.take(len(p)); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-tw.done:
+			return 0, io.ErrClosedPipe
+		}
+	}
+	return tw.w.Write(p)
+}
+
+// sizeLimitedReader fails with errTooLarge once more than max bytes have been read.
+type sizeLimitedReader struct {
+	r   io.Reader
+	max int64
+}
+
+func (sr *sizeLimitedReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	sr.max -= int64(n)
+	if sr.max < 0 {
+		return 0, errTooLarge
+	}
+	return n, err
+}
+
+// admit reserves one of the handler's concurrent transfers, reporting false if none are left.
+func (h *Handler) admit() bool {
+	h.Lock()
+	defer h.Unlock()
+
+	if h.quotas.Transfers > 0 && h.transfers >= h.quotas.Transfers {
+		return false
+	}
+	h.transfers++
+	return true
+}
+
+// leave releases a transfer reserved by admit.
+func (h *Handler) leave() {
+	h.Lock()
+	defer h.Unlock()
+	h.transfers--
+}
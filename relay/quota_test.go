@@ -0,0 +1,171 @@
+package relay
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC)
+	b := newBucket(1000)
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		take    int
+		want    time.Duration
+	}{
+		{"bursts up to a second's worth", 0, 1000, 0},
+		{"waits for tokens it owes", 0, 500, 500 * time.Millisecond},
+		{"refills over time", time.Second, 750, 250 * time.Millisecond},
+		{"refills no more than a burst", time.Hour, 1000, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now = now.Add(test.elapsed)
+			if got := b.take(test.take); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	t.Run("unlimited", func(t *testing.T) {
+		if b := newBucket(0); b != nil {
+			t.Errorf("got %v, want nil", b)
+		}
+	})
+}
+
+func TestHandlerQuotas(t *testing.T) {
+	offer := func(handler *Handler, addr string, size int64) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(http.MethodPost, "/file", nil)
+		request.RemoteAddr = addr
+		if size >= 0 {
+			request.Header.Set(sizeHeader, fmt.Sprint(size))
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		return w
+	}
+	// send streams body to whichever receiver joins the offer, returning the sender's response
+	send := func(handler *Handler, secret, token string, body string) <-chan *httptest.ResponseRecorder {
+		sent := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			request, _ := http.NewRequest(http.MethodPut, "/file/"+secret, strings.NewReader(body))
+			request.Header.Set(senderTokenHeader, token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+			sent <- w
+		}()
+		return sent
+	}
+	receive := func(handler *Handler, secret string) *httptest.ResponseRecorder {
+		request := receiveRequest(handler, secret)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		return w
+	}
+
+	t.Run("offers per IP", func(t *testing.T) {
+		handler := NewHandler(newSecretList("a-a-a", "b-b-b", "c-c-c"), ioutil.Discard, WithQuotas(Quotas{OffersPerIP: 1}))
+
+		first := offer(handler, "192.0.2.1:1234", -1)
+		if got, want := offer(handler, "192.0.2.1:5678", -1).Code, http.StatusTooManyRequests; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := offer(handler, "198.51.100.7:1234", -1).Code, http.StatusOK; got != want {
+			t.Errorf("got %v from another IP, want %v", got, want)
+		}
+
+		sent := send(handler, "a-a-a", first.Header().Get(senderTokenHeader), "file contents")
+		receive(handler, "a-a-a")
+		<-sent
+		time.Sleep(50 * time.Millisecond) // for the offer to be destroyed
+		if got, want := offer(handler, "192.0.2.1:1234", -1).Code, http.StatusOK; got != want {
+			t.Errorf("got %v once the first offer was received, want %v", got, want)
+		}
+	})
+
+	t.Run("advertised size", func(t *testing.T) {
+		handler := NewHandler(newSecretList("a-a-a", "b-b-b"), ioutil.Discard, WithQuotas(Quotas{MaxSize: 1000}))
+
+		w := offer(handler, "192.0.2.1:1234", 1001)
+		if got, want := w.Code, http.StatusRequestEntityTooLarge; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := w.Body.String(), errTooLarge.Error()+"\n"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := offer(handler, "192.0.2.1:1234", 1000).Code, http.StatusOK; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("streamed size", func(t *testing.T) {
+		handler := NewHandler(newSecretList("a-a-a"), ioutil.Discard, WithQuotas(Quotas{MaxSize: 10}))
+
+		token := offer(handler, "192.0.2.1:1234", -1).Header().Get(senderTokenHeader)
+		sent := send(handler, "a-a-a", token, strings.Repeat("X", int(sealedSize(10, 0))+1))
+		if got, want := receive(handler, "a-a-a").Code, http.StatusRequestEntityTooLarge; got != want {
+			t.Errorf("receiver got %v, want %v", got, want)
+		}
+		if got, want := (<-sent).Code, http.StatusRequestEntityTooLarge; got != want {
+			t.Errorf("sender got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("concurrent transfers", func(t *testing.T) {
+		handler := NewHandler(newSecretList("a-a-a", "b-b-b"), ioutil.Discard, WithQuotas(Quotas{Transfers: 1}))
+		token := offer(handler, "192.0.2.1:1234", -1).Header().Get(senderTokenHeader)
+		offer(handler, "192.0.2.1:1234", -1)
+
+		received := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			received <- receive(handler, "a-a-a")
+		}()
+		time.Sleep(50 * time.Millisecond) // for the receiver to join
+
+		if got, want := receive(handler, "b-b-b").Code, http.StatusTooManyRequests; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+
+		send(handler, "a-a-a", token, "file contents")
+		<-received
+		if !handler.admit() {
+			t.Error("still refusing receivers once the first transfer completed")
+		}
+	})
+
+	t.Run("bandwidth", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			quotas Quotas
+		}{
+			{"per transfer", Quotas{TransferRate: 10000}},
+			{"in total", Quotas{TotalRate: 10000}},
+		}
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				handler := NewHandler(newSecretList("a-a-a"), ioutil.Discard, WithQuotas(test.quotas))
+				token := offer(handler, "192.0.2.1:1234", -1).Header().Get(senderTokenHeader)
+
+				start := time.Now()
+				send(handler, "a-a-a", token, strings.Repeat("X", 15000))
+				if got, want := receive(handler, "a-a-a").Body.Len(), 15000; got != want {
+					t.Errorf("got %v bytes, want %v", got, want)
+				}
+				// a second's burst, then half a second for the rest
+				if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+					t.Errorf("took %v, want at least 500ms", elapsed)
+				}
+			})
+		}
+	})
+}
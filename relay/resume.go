@@ -0,0 +1,257 @@
+package relay
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxRetries = 5
+	retryDelay = 500 * time.Millisecond
+)
+
+// statusError is an unexpected HTTP status code from the relay.
+type statusError struct {
+	action string
+	code   int
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("bad status code %v: %v", e.action, e.code)
+}
+
+// checkStatus returns an error for any unsuccessful response from the relay.
+func checkStatus(resp *http.Response, action string) error {
+	switch {
+	case resp.StatusCode == http.StatusConflict:
+		return ErrDeclined
+	case resp.StatusCode == http.StatusGone:
+		return ErrAborted
+	case resp.StatusCode == http.StatusGatewayTimeout:
+		return ErrStalled
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return quotaError(ErrTooManyRequests, resp)
+	case resp.StatusCode == http.StatusRequestEntityTooLarge:
+		return quotaError(ErrTooLarge, resp)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return statusError{action, resp.StatusCode}
+	}
+	return nil
+}
+
+// quotaError wraps err with the relay's explanation, if it gave one.
+func quotaError(err error, resp *http.Response) error {
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	if reason := strings.TrimSpace(string(msg)); reason != "" {
+		return fmt.Errorf("%w: %v", err, reason)
+	}
+	return err
+}
+
+// resumable reports whether a transfer that failed with err might succeed if retried.
+//
+// Decryption failures, offers the relay has forgotten, declined, abandoned or stalled transfers, exceeded quotas
+// and relays shutting down are permanent.
+func resumable(err error) bool {
+	var status statusError
+	if errors.As(err, &status) {
+		return status.code != http.StatusNotFound && status.code != http.StatusRequestTimeout &&
+			status.code != http.StatusServiceUnavailable
+	}
+	return !errors.Is(err, errCorrupt) && !errors.Is(err, ErrWrongSecret) && !errors.Is(err, ErrAborted) &&
+		!errors.Is(err, ErrDeclined) && !errors.Is(err, ErrStalled) && !errors.Is(err, ErrTooManyRequests) && !errors.Is(err, ErrTooLarge) &&
+		!errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// sleep pauses for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// upload streams file to the receiver and returns its SHA-256.
+//
+// If the transfer is interrupted and file is an io.Seeker, it waits for the receiver
+// to reconnect and resumes from the chunk where the receiver left off.
+func (c *Client) upload(ctx context.Context, nameplate string, key []byte, file io.Reader, m *meter) (sum []byte, err error) {
+	var offset int64
+	d := newDigest()
+	err = c.put(ctx, nameplate, key, meteredReader{file, m}, offset, d)
+
+	for retries := 1; err != nil; retries++ {
+		seeker, ok := file.(io.Seeker)
+		if !ok || retries > maxRetries || !resumable(err) {
+			return nil, fmt.Errorf("sending: %w", err)
+		}
+		if err := sleep(ctx, time.Duration(retries)*retryDelay); err != nil {
+			return nil, fmt.Errorf("sending: %w", err)
+		}
+
+		var resumed int64
+		if resumed, err = c.resumeOffset(ctx, nameplate); err != nil {
+			continue
+		}
+		if resumed > offset {
+			retries = 0 // we're making progress
+		}
+		offset = resumed
+
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("seeking to resume: %w", err)
+		}
+		m.rewind(offset)
+		err = c.put(ctx, nameplate, key, meteredReader{file, m}, offset, d)
+	}
+
+	return d.sum(), nil
+}
+
+// put sends file to the relay, starting at offset.
+func (c *Client) put(ctx context.Context, nameplate string, key []byte, file io.Reader, offset int64, d *digest) error {
+	body, err := newSealer(file, key, uint64(offset/chunkSize), d)
+	if err != nil {
+		return fmt.Errorf("encrypting: %w", err)
+	}
+
+	req := c.senderRequest(ctx, http.MethodPut, nameplate, "", body)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp, "sending")
+}
+
+// resumeOffset waits for the receiver to reconnect and returns the offset it needs the file from.
+func (c *Client) resumeOffset(ctx context.Context, nameplate string) (int64, error) {
+	j, err := c.handshake(ctx, nameplate)
+	if err != nil {
+		return 0, err
+	}
+	if j.offset == "" {
+		return 0, nil
+	}
+
+	offset, err := strconv.ParseInt(j.offset, 10, 64)
+	if err != nil || offset < 0 || offset%chunkSize != 0 {
+		return 0, fmt.Errorf("invalid resume offset: %q", j.offset)
+	}
+	return offset, nil
+}
+
+// download is a received stream that reconnects and resumes if its connection drops.
+//
+// It hashes everything it reads, and verifies the sender's digest at the end of the stream.
+type download struct {
+	c         *Client
+	ctx       context.Context
+	nameplate string
+	token     string
+	key       []byte
+	stream    *opener
+	meter     *meter
+	hash      hash.Hash
+	digest    []byte
+	offset    int64
+	retries   int
+	ended     bool
+}
+
+func (d *download) Read(p []byte) (int, error) {
+	n, err := d.stream.Read(p)
+	for err != nil && err != io.EOF && resumable(err) && d.retries < maxRetries {
+		d.retries++
+		if err = sleep(d.ctx, time.Duration(d.retries)*retryDelay); err != nil {
+			break
+		}
+		if err = d.resume(); err == nil {
+			n, err = d.stream.Read(p)
+		}
+	}
+	if err != nil && !d.ended {
+		d.ended = true
+		if d.ctx.Err() != nil {
+			d.c.abort(d.nameplate, d.token)
+		}
+	}
+
+	if n > 0 {
+		d.retries = 0
+	}
+	d.offset += int64(n)
+	d.hash.Write(p[:n])
+	d.meter.add(n)
+
+	if err == io.EOF && d.digest == nil {
+		sum := d.hash.Sum(nil)
+		if !hmac.Equal(sum, d.stream.digest) {
+			return n, ErrDigestMismatch
+		}
+		d.digest = sum
+	}
+	return n, err
+}
+
+// Close closes the stream, abandoning the transfer unless it has been read to the end.
+func (d *download) Close() error {
+	if !d.ended {
+		d.ended = true
+		d.c.abort(d.nameplate, d.token)
+	}
+	return d.stream.Close()
+}
+
+// Digest returns the verified SHA-256 of the file once it has been completely read.
+func (d *download) Digest() []byte {
+	return d.digest
+}
+
+// resume reconnects to the relay, asking the sender to restart from the chunk containing d.offset.
+func (d *download) resume() error {
+	d.stream.Close()
+	aligned := d.offset - d.offset%chunkSize
+
+	req, _ := http.NewRequestWithContext(d.ctx, http.MethodGet, d.c.base+"/file/"+d.nameplate, nil)
+	req.Header.Set(receiverTokenHeader, d.token)
+	req.Header.Set(resumeHeader, strconv.FormatInt(aligned, 10))
+
+	resp, err := d.c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	if err := checkStatus(resp, "resuming"); err != nil {
+		resp.Body.Close()
+		return err
+	}
+
+	stream, err := newOpener(resp.Body, d.key, uint64(aligned/chunkSize))
+	if err != nil {
+		resp.Body.Close()
+		return err
+	}
+
+	// skip whatever was already read from the first resumed chunk
+	if _, err := io.CopyN(ioutil.Discard, stream, d.offset-aligned); err != nil {
+		stream.Close()
+		return err
+	}
+
+	d.stream = stream
+	return nil
+}
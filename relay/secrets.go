@@ -1,24 +1,166 @@
 package relay
 
-import "math/rand"
+import (
+	"bufio"
+	crand "crypto/rand"
+	"encoding/binary"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
 
-// Secrets is a generator that provides unique secret strings in the form "first-second-third."
+const (
+	// defaultNameplates is the largest number that begins a secret unless configured otherwise.
+	defaultNameplates = 999
+	// widenAfter is how many secrets may be taken in a row before numbers are drawn from a range ten times larger.
+	widenAfter = 10
+	// maxNameplates bounds how far the range of numbers widens.
+	maxNameplates = 1000000000
+)
+
+// Secrets is a generator that provides secret strings for offers: a number, which the sender's password
+// follows to make a code like "7-guitarist-revenge", and optionally some words of the relay's own.
 type Secrets struct {
-	rng *rand.Rand
+	rng     *rand.Rand
+	words   []string
+	count   int
+	numbers int
+}
+
+// SecretsOption configures a generator created by NewSecrets.
+type SecretsOption func(*Secrets)
+
+// WithRand draws secrets from rng instead of crypto/rand.
+//
+// If rng is deterministic, the secret strings will be as well, which is only useful for tests:
+// anyone who can guess its seed can predict them. A *rand.Rand isn't safe for concurrent use
+// unless its Source is.
+func WithRand(rng *rand.Rand) SecretsOption {
+	return func(s *Secrets) {
+		s.rng = rng
+	}
+}
+
+// WithNumbers begins secrets with a number from 1 to max, rather than up to 999.
+// Zero leaves the number out, so secrets are only words.
+func WithNumbers(max int) SecretsOption {
+	return func(s *Secrets) {
+		s.numbers = max
+	}
+}
+
+// WithWordCount follows the number in each secret with n words, rather than none.
+func WithWordCount(n int) SecretsOption {
+	return func(s *Secrets) {
+		s.count = n
+	}
 }
 
-// NewSecrets returns a new secret generator based on the provided random-number generator.
+// WithWordlist draws words from list instead of the built-in list of common English words,
+// such as one for another language from Wordlist. Secrets are only as unique as the list,
+// so check it with ValidateWordlist first. An empty list is ignored.
+func WithWordlist(list []string) SecretsOption {
+	return func(s *Secrets) {
+		if len(list) > 0 {
+			s.words = list
+		}
+	}
+}
+
+// NewSecrets returns a new secret generator, along with the entropy of its secrets in bits:
+// the base-2 logarithm of the number of secrets it might generate.
 //
-// If `rng` is deterministic, the secret strings will be as well, so be sure to
-// seed your generator with something pseudo-random like the current time in order to
-// generate unique secrets.
-func NewSecrets(rng *rand.Rand) Secrets {
-	return Secrets{rng: rng}
+// Unless configured otherwise, it draws a number up to 999 from crypto/rand, for roughly 10 bits,
+// though the range grows when the relay is busy (see Crowded).
+// Like a magic-wormhole nameplate, that only needs to be unique among the relay's offers:
+// the code's secrecy rests on the sender's password, which the relay never sees, and which the key exchange
+// lets an attacker guess just once per attempt. Relay words make offers harder to find by guessing secrets,
+// which the relay also rate limits, so size them against the number of guesses an attacker could make
+// within an offer's lifetime.
+func NewSecrets(opts ...SecretsOption) (Secrets, float64) {
+	s := Secrets{
+		rng:     rand.New(cryptoSource{}),
+		words:   words,
+		numbers: defaultNameplates,
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	if s.numbers < 1 && s.count < 1 {
+		s.numbers = defaultNameplates
+	}
+
+	bits := float64(s.count) * math.Log2(float64(len(s.words)))
+	if s.numbers > 0 {
+		bits += math.Log2(float64(s.numbers))
+	}
+	return s, bits
 }
 
 // String returns the next random secret from the generator.
 func (s Secrets) String() string {
-	n := len(words)
-	a, b, c := s.rng.Intn(n), s.rng.Intn(n), s.rng.Intn(n)
-	return words[a] + "-" + words[b] + "-" + words[c]
+	return s.Crowded(0)
 }
+
+// Crowded returns the next random secret from the generator, after collisions secrets in a row
+// turned out to be taken. Like magic-wormhole's nameplates, the number at the start of the secret is drawn
+// from a range ten times larger for every few collisions, so that a busy relay never runs out of secrets,
+// while a quiet one keeps them short.
+func (s Secrets) Crowded(collisions int) string {
+	phrase := s.phrase(s.count)
+	if s.numbers < 1 {
+		return phrase
+	}
+	max := s.numbers
+	for i := 0; i < collisions/widenAfter && max <= maxNameplates/10; i++ {
+		max *= 10
+	}
+	number := strconv.Itoa(1 + s.rng.Intn(max))
+	if phrase == "" {
+		return number
+	}
+	return number + "-" + phrase
+}
+
+// phrase returns n random words joined by hyphens.
+func (s Secrets) phrase(n int) string {
+	picked := make([]string, n)
+	for i := range picked {
+		picked[i] = s.words[s.rng.Intn(len(s.words))]
+	}
+	return strings.Join(picked, "-")
+}
+
+// ReadWordlist reads a list of words for WithWordlist, one per line.
+// Blank lines and lines beginning with "#" are skipped, and words are lowercased.
+func ReadWordlist(r io.Reader) ([]string, error) {
+	var list []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		word := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		list = append(list, word)
+	}
+	return list, scanner.Err()
+}
+
+// cryptoSource is a math/rand Source that draws from crypto/rand.
+type cryptoSource struct{}
+
+func (cryptoSource) Int63() int64 {
+	return int64(cryptoSource{}.Uint64() &^ (1 << 63))
+}
+
+func (cryptoSource) Uint64() uint64 {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return binary.BigEndian.Uint64(b[:])
+}
+
+func (cryptoSource) Seed(int64) {}
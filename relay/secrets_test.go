@@ -2,23 +2,23 @@ package relay
 
 import (
 	"fmt"
+	"math"
 	"math/rand"
+	"strconv"
+	"strings"
 	"testing"
-	"time"
 )
 
 func ExampleNewSecrets() {
-	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
-	secrets := NewSecrets(rng)
-	fmt.Println(secrets.String())
+	secrets, bits := NewSecrets(WithWordCount(2))
+	fmt.Printf("%v (%.0f bits)\n", secrets, bits)
 }
 
 func TestSecretCollision(t *testing.T) {
 	const n = 1000
 	seen := make([]string, 0, n)
 
-	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
-	secrets := NewSecrets(rng)
+	secrets, _ := NewSecrets(WithNumbers(0), WithWordCount(3))
 
 	for i := 0; i < n; i++ {
 		secret := secrets.String()
@@ -32,8 +32,7 @@ func TestSecretCollision(t *testing.T) {
 }
 
 func TestSecretConsistency(t *testing.T) {
-	rng := rand.New(rand.NewSource(1))
-	secrets := NewSecrets(rng)
+	secrets, _ := NewSecrets(WithRand(rand.New(rand.NewSource(1))), WithNumbers(0), WithWordCount(3))
 	seq := []string{"fast-blue-began", "fire-type-here", "better-chance-glad"}
 
 	for _, want := range seq {
@@ -43,3 +42,96 @@ func TestSecretConsistency(t *testing.T) {
 		}
 	}
 }
+
+func TestSecretsOptions(t *testing.T) {
+	alphabet := []string{"alpha", "bravo", "charlie", "delta"}
+	wordBits := math.Log2(float64(len(words)))
+
+	tests := []struct {
+		name    string
+		opts    []SecretsOption
+		numbers int // the largest number that begins a secret, or zero for none
+		words   int
+		list    []string
+		bits    float64
+	}{
+		{"defaults", nil, 999, 0, words, math.Log2(999)},
+		{"numbers", []SecretsOption{WithNumbers(99)}, 99, 0, words, math.Log2(99)},
+		{"numbers and words", []SecretsOption{WithNumbers(99), WithWordCount(2)}, 99, 2, words, math.Log2(99) + 2*wordBits},
+		{"only words", []SecretsOption{WithNumbers(0), WithWordCount(5)}, 0, 5, words, 5 * wordBits},
+		{"neither", []SecretsOption{WithNumbers(0)}, 999, 0, words, math.Log2(999)},
+		{"wordlist", []SecretsOption{WithNumbers(0), WithWordCount(3), WithWordlist(alphabet)}, 0, 3, alphabet, 6},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			secrets, bits := NewSecrets(test.opts...)
+			if math.Abs(bits-test.bits) > 1e-9 {
+				t.Errorf("got %v bits, want %v", bits, test.bits)
+			}
+
+			for i := 0; i < 100; i++ {
+				parts := strings.Split(secrets.String(), "-")
+				if test.numbers > 0 {
+					n, err := strconv.Atoi(parts[0])
+					if err != nil || n < 1 || n > test.numbers {
+						t.Fatalf("got %q, want a number from 1 to %v", parts[0], test.numbers)
+					}
+					parts = parts[1:]
+				}
+				if len(parts) != test.words {
+					t.Fatalf("got %v words, want %v", len(parts), test.words)
+				}
+				for _, word := range parts {
+					if !contains(test.list, word) {
+						t.Fatalf("got %q, which isn't in the wordlist", word)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestReadWordlist(t *testing.T) {
+	list, err := ReadWordlist(strings.NewReader("# words\nAlpha\n\n  bravo \ncharlie\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(list, ","), "alpha,bravo,charlie"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSecretsCrowded(t *testing.T) {
+	secrets, _ := NewSecrets(WithNumbers(9), WithRand(rand.New(rand.NewSource(1))))
+
+	tests := []struct {
+		collisions int
+		max        int
+	}{
+		{0, 9},
+		{widenAfter - 1, 9},
+		{widenAfter, 90},
+		{3 * widenAfter, 9000},
+		{1000 * widenAfter, maxNameplates},
+	}
+	for _, test := range tests {
+		t.Run(strconv.Itoa(test.collisions), func(t *testing.T) {
+			largest := 0
+			for i := 0; i < 1000; i++ {
+				n, err := strconv.Atoi(secrets.Crowded(test.collisions))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n < 1 || n > test.max {
+					t.Fatalf("got %v, want 1 to %v", n, test.max)
+				}
+				if n > largest {
+					largest = n
+				}
+			}
+			if largest <= test.max/10 {
+				t.Errorf("got at most %v, want up to %v", largest, test.max)
+			}
+		})
+	}
+}
@@ -2,57 +2,386 @@ package relay
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	filenameHeader = "suggested-filename"
-	offerTimeout   = 10 * time.Minute
+	exchangeHeader      = "key-exchange"
+	senderTokenHeader   = "sender-token"
+	confirmationHeader  = "key-confirmation"
+	receiverTokenHeader = "receiver-token"
+	resumeHeader        = "resume-offset"
+	confirmsHeader      = "receiver-confirms"
+	expiresInHeader     = "offer-expires-in"
+	expiresHeader       = "offer-expires"
+	offerTimeout        = 10 * time.Minute
+	maxOfferTimeout     = time.Hour
+	resumeTimeout       = time.Minute
+	maxSecretAttempts   = 100 // before concluding that every secret is taken
+)
+
+var (
+	errClaimed   = errors.New("offer already claimed")
+	errDraining  = errors.New("relay is shutting down")
+	errStalled   = errors.New("transfer stalled")
+	errNoSecrets = errors.New("no unused secrets left")
 )
 
 type offer struct {
-	filename string
+	moved    int64  // bytes streamed to receivers, accessed atomically, so first for alignment
+	id       string // identifies the offer in logs, without revealing its secret
+	token    string // authorizes the sender's requests
+	created  time.Time
+	meta     Metadata
 	address  string
-	receiver chan http.ResponseWriter
+	exchange string
+	peer     chan *join
+	accept   chan struct{} // the receiver's go-ahead, if it asked to see the metadata first
 	ctx      context.Context
 	cancel   context.CancelFunc
+
+	sync.Mutex
+	expiry    *time.Timer
+	claims    map[string]claim // receiver tokens to their claims
+	accepted  string           // the token of the receiver the sender is streaming to
+	current   *join            // the join the sender has handshaken with, but not yet answered
+	rejected  int              // receivers the sender rejected for using the wrong code
+	aborted   bool             // whether either side abandoned the transfer
+	declined  bool             // whether the receiver turned the offer down
+	expired   bool             // whether the offer timed out
+	stopped   bool             // whether the relay shut down before the transfer completed
+	stalled   bool             // whether the transfer stopped making progress
+	oversized bool             // whether the sender streamed more than the relay's size limit
+	streaming bool             // whether the sender is streaming to a receiver right now
+}
+
+// claim is a receiver's key exchange message, waiting to be redeemed when it joins the offer.
+type claim struct {
+	exchange string
+	address  string
+}
+
+// join is a receiver's connection, which is handed to the sender's handshake
+// and then either rejected or streamed the file.
+type join struct {
+	exchange     string
+	confirmation string
+	offset       string
+	token        string
+	address      string
+	claimant     string // the address that claimed the offer, which may differ from the one joining
+	confirms     bool   // whether the receiver decides to accept the offer after seeing its metadata
+	ctx          context.Context
+
+	w         http.ResponseWriter
+	done      chan struct{}
+	taken     bool
+	withdrawn bool
+	answered  bool // whether the receiver's response has begun
+}
+
+// expireIn cancels the offer after d, replacing any previous deadline.
+// A zero duration removes the deadline.
+func (o *offer) expireIn(d time.Duration) {
+	o.Lock()
+	defer o.Unlock()
+
+	if o.expiry != nil {
+		o.expiry.Stop()
+		o.expiry = nil
+	}
+	if d > 0 {
+		o.expiry = time.AfterFunc(d, o.expire)
+	}
+}
+
+func (o *offer) expire() {
+	o.Lock()
+	o.expired = true
+	o.Unlock()
+	o.cancel()
+}
+
+// abort cancels the offer on behalf of either side.
+func (o *offer) abort() {
+	o.Lock()
+	o.aborted = true
+	o.Unlock()
+	o.cancel()
+}
+
+// decline cancels the offer because the receiver turned it down.
+func (o *offer) decline() {
+	o.Lock()
+	o.declined = true
+	o.Unlock()
+	o.cancel()
+}
+
+// stop cancels the offer because the relay is shutting down.
+func (o *offer) stop() {
+	o.Lock()
+	o.stopped = true
+	o.Unlock()
+	o.cancel()
+}
+
+// stall cancels the offer because its transfer stopped making progress.
+func (o *offer) stall() {
+	o.Lock()
+	o.stalled = true
+	o.Unlock()
+	o.cancel()
+}
+
+// oversize cancels the offer because its sender streamed more than the relay allows.
+func (o *offer) oversize() {
+	o.Lock()
+	o.oversized = true
+	o.Unlock()
+	o.cancel()
+}
+
+// stream records whether the sender is streaming to a receiver.
+func (o *offer) stream(streaming bool) {
+	o.Lock()
+	defer o.Unlock()
+	o.streaming = streaming
+}
+
+// timedOut reports whether the offer expired.
+func (o *offer) timedOut() bool {
+	o.Lock()
+	defer o.Unlock()
+	return o.expired
+}
+
+// closedStatus is the status for requests that were waiting when the offer was cancelled:
+// 409 if the receiver declined it, 410 if a client abandoned the transfer, 504 if it stalled,
+// 413 if the file was too large, 503 if the relay shut down, or 408 if it timed out.
+func (o *offer) closedStatus() int {
+	o.Lock()
+	defer o.Unlock()
+
+	if o.declined {
+		return http.StatusConflict
+	}
+	if o.aborted {
+		return http.StatusGone
+	}
+	if o.stalled {
+		return http.StatusGatewayTimeout
+	}
+	if o.oversized {
+		return http.StatusRequestEntityTooLarge
+	}
+	if o.stopped {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusRequestTimeout
+}
+
+// sentBy reports whether r comes from the offer's sender, by the token it was given when making the offer.
+func (o *offer) sentBy(r *http.Request) bool {
+	token := r.Header.Get(senderTokenHeader)
+	return token != "" && hmac.Equal([]byte(token), []byte(o.token))
+}
+
+// holds reports whether token belongs to a receiver that has claimed the offer.
+func (o *offer) holds(token string) bool {
+	o.Lock()
+	defer o.Unlock()
+
+	_, claimed := o.claims[token]
+	return token != "" && (claimed || token == o.accepted)
+}
+
+// accepts reports whether token belongs to the receiver the sender accepted,
+// after checking its key confirmation.
+func (o *offer) accepts(token string) bool {
+	o.Lock()
+	defer o.Unlock()
+
+	return token != "" && token == o.accepted
+}
+
+// claim registers a receiver's key exchange message, returning a token with which it can join.
+// Outstanding claims count toward the offer's limit of wrong codes,
+// so an offer can't be claimed more times than it has attempts remaining.
+func (o *offer) claim(exchange, address string, limit int) (token string, err error) {
+	o.Lock()
+	defer o.Unlock()
+
+	if o.accepted != "" || (limit > 0 && o.rejected+len(o.claims) >= limit) {
+		return "", errClaimed
+	}
+	if token, err = newToken(); err != nil {
+		return "", fmt.Errorf("generating receiver token: %w", err)
+	}
+	o.claims[token] = claim{exchange: exchange, address: address}
+	return token, nil
+}
+
+// expireClaim withdraws a claim that hasn't been redeemed, returning the address that made it.
+func (o *offer) expireClaim(token string) (address string, ok bool) {
+	o.Lock()
+	defer o.Unlock()
+
+	c, ok := o.claims[token]
+	delete(o.claims, token)
+	return c.address, ok
+}
+
+// redeem returns the claim made with token.
+// The receiver that's already been accepted may redeem its token again, without one, to resume.
+func (o *offer) redeem(token string) (c claim, ok bool) {
+	o.Lock()
+	defer o.Unlock()
+
+	if token != "" && token == o.accepted {
+		return claim{}, true
+	}
+	c, ok = o.claims[token]
+	delete(o.claims, token)
+	return c, ok
+}
+
+// handshake records the join that the sender is deciding whether to accept.
+func (o *offer) handshake(j *join) {
+	o.Lock()
+	defer o.Unlock()
+	o.current = j
+}
+
+// answer removes and returns the join the sender handshook with, if any.
+func (o *offer) answer() *join {
+	o.Lock()
+	defer o.Unlock()
+	j := o.current
+	o.current = nil
+	return j
+}
+
+// reject counts a receiver the sender turned away, returning the total.
+func (o *offer) reject() int {
+	o.Lock()
+	defer o.Unlock()
+	o.rejected++
+	return o.rejected
+}
+
+// pair hands the receiver's connection to the sender, unless the receiver has already given up.
+func (o *offer) pair(j *join) bool {
+	o.Lock()
+	defer o.Unlock()
+
+	if j.withdrawn {
+		return false
+	}
+	j.taken = true
+	if j.token != "" {
+		o.accepted = j.token
+	}
+	return true
+}
+
+// dismiss takes the receiver's connection in order to turn it away, unless the receiver has already given up.
+func (o *offer) dismiss(j *join) bool {
+	o.Lock()
+	defer o.Unlock()
+
+	if j.withdrawn {
+		return false
+	}
+	j.taken = true
+	return true
+}
+
+// withdraw stops the sender from taking j, reporting false if it already has.
+func (o *offer) withdraw(j *join) bool {
+	o.Lock()
+	defer o.Unlock()
+
+	if j.taken {
+		return false
+	}
+	j.withdrawn = true
+	return true
 }
 
 // Handler is the HTTP request handler that relays messages between clients.
 type Handler struct {
-	router  *http.ServeMux
-	secrets fmt.Stringer
-	logger  io.Writer
+	router   *http.ServeMux
+	secrets  fmt.Stringer
+	logger   Logger
+	limits   Limits
+	stalls   StallLimits
+	quotas   Quotas
+	rate     *bucket       // shared by every transfer
+	lifetime time.Duration // of offers whose sender doesn't choose
+	maxLife  time.Duration // the longest a sender may choose
+	failures *limiter
+	cluster  Cluster
+	proxies  []*net.IPNet
+	metrics  *metrics
 
 	sync.RWMutex
-	offers map[string]offer
+	offers    map[string]*offer
+	ended     map[string]int // secrets of offers that ended early, to the status that explains why
+	senders   map[string]int // client IPs to the number of offers they hold
+	transfers int            // receivers joined to offers
+	draining  bool
+	active    sync.WaitGroup // offers that haven't been destroyed yet
 }
 
 // NewHandler returns a new Handler.
 //
-// It generates secret strings via the provided Stringer and logs events to the provided Writer.
-func NewHandler(secrets fmt.Stringer, logger io.Writer) *Handler {
+// It generates secret strings via the provided Stringer and logs events at LevelInfo and above
+// to the provided Writer, in logfmt.
+// Unless configured otherwise, it enforces DefaultLimits and DefaultStallLimits, and keeps its offers to itself.
+func NewHandler(secrets fmt.Stringer, logger io.Writer, opts ...HandlerOption) *Handler {
 	h := &Handler{
-		secrets: secrets,
-		offers:  make(map[string]offer),
-		router:  http.NewServeMux(),
-		logger:  logger,
+		secrets:  secrets,
+		offers:   make(map[string]*offer),
+		ended:    make(map[string]int),
+		senders:  make(map[string]int),
+		router:   http.NewServeMux(),
+		logger:   NewLogfmtLogger(logger, LevelInfo),
+		limits:   DefaultLimits,
+		stalls:   DefaultStallLimits,
+		lifetime: offerTimeout,
+		maxLife:  maxOfferTimeout,
+		cluster:  Cluster{Store: NewMemoryStore()},
+		metrics:  newMetrics(),
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.failures = newLimiter(h.limits)
+	h.rate = newBucket(h.quotas.TotalRate)
 
 	h.router.Handle("/file", h.handleNew())
 	h.router.Handle("/file/", h.handleExisting())
+	h.router.Handle("/metrics", h.handleMetrics())
 
 	return h
 }
 
 // ServeHTTP allows the handler to serve HTTP requests.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h.router.ServeHTTP(w, r)
+	h.router.ServeHTTP(w, h.proxied(r))
 }
 
 func (h *Handler) handleNew() http.HandlerFunc {
@@ -62,16 +391,44 @@ func (h *Handler) handleNew() http.HandlerFunc {
 			return
 		}
 
-		filename := r.Header.Get(filenameHeader)
-		secret, err := h.createOffer(filename, r.RemoteAddr)
+		meta := readMetadata(r.Header)
+		if h.quotas.MaxSize > 0 && meta.Size > h.quotas.MaxSize {
+			h.logger.Log(LevelInfo, "offer refused", "sender", r.RemoteAddr, "reason", "too large", "size", meta.Size)
+			h.metrics.count(&h.metrics.refusals)
+			http.Error(w, errTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		exchange := r.Header.Get(exchangeHeader)
+		lifetime := h.offerLifetime(r.Header.Get(expiresInHeader))
+		secret, off, err := h.createOffer(meta, exchange, r.RemoteAddr, lifetime)
+		if err == errDraining {
+			h.logger.Log(LevelInfo, "offer refused", "sender", r.RemoteAddr, "reason", "draining")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if err == errNoSecrets {
+			h.logger.Log(LevelWarn, "offer refused", "sender", r.RemoteAddr, "reason", "no unused secrets")
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err == errTooManyOffers {
+			h.logger.Log(LevelInfo, "offer refused", "sender", r.RemoteAddr, "reason", "too many offers")
+			h.metrics.count(&h.metrics.refusals)
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
 		if err != nil {
-			fmt.Fprintln(h.logger, fmt.Errorf("creating offer: %w", err))
+			h.logger.Log(LevelError, "offer creation failed", "sender", r.RemoteAddr, "error", err)
+			h.metrics.error(causeCreate)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		h.logger.Log(LevelInfo, "offer created", "transfer", off.id, "sender", r.RemoteAddr, "size", meta.Size, "lifetime", lifetime)
 
+		w.Header().Set(senderTokenHeader, off.token)
+		w.Header().Set(expiresHeader, off.created.Add(lifetime).UTC().Format(time.RFC3339))
 		if _, err := fmt.Fprintln(w, secret); err != nil {
-			fmt.Fprintln(h.logger, fmt.Errorf("sending secret: %w", err))
+			h.logger.Log(LevelWarn, "sending secret failed", "transfer", off.id, "error", err)
 			return
 		}
 	}
@@ -86,94 +443,618 @@ func (h *Handler) handleExisting() http.HandlerFunc {
 			return
 		}
 
+		r, forwarded := h.forwarded(r)
+
+		client := clientIP(r.RemoteAddr)
+		if h.failures.locked(client) {
+			h.logger.Log(LevelWarn, "client refused", "client", client, "reason", "locked out")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
 		secret := split[1]
 		off, err := h.findOffer(secret)
+		if err != nil && !forwarded {
+			if node, err := h.cluster.Store.Lookup(secret); err == nil && node != h.cluster.Self {
+				h.forward(w, r, node)
+				return
+			}
+		}
 		if err != nil {
-			fmt.Fprintln(h.logger, fmt.Errorf("finding offer: %w", err))
+			if status, ok := h.endedStatus(secret); ok {
+				// a client that was part of the transfer is retrying, so tell it why it's gone
+				w.WriteHeader(status)
+				return
+			}
+			// never log the secret, which may be a near miss of a real one
+			h.logger.Log(LevelWarn, "offer not found", "client", r.RemoteAddr)
+			h.metrics.count(&h.metrics.failedLookups)
+			h.fail(client)
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 
-		switch r.Method {
-		case http.MethodPut:
+		action := ""
+		if len(split) > 2 {
+			action = split[2]
+		}
+
+		switch {
+		case r.Method == http.MethodPost && action == "claim":
+			h.handleClaim(w, r, off)
+		case r.Method == http.MethodDelete && action == "claim":
+			h.handleReject(w, r, off)
+		case r.Method == http.MethodPost && action == "accept":
+			h.handleAccept(w, r, off)
+		case r.Method == http.MethodPost && action == "decline":
+			h.handleDecline(w, r, off)
+		case r.Method == http.MethodGet && action == "handshake":
+			h.handleHandshake(w, r, off)
+		case r.Method == http.MethodPut && action == "":
 			h.handleSend(w, r, off)
-		case http.MethodGet:
+		case r.Method == http.MethodGet && action == "":
 			h.handleReceive(w, r, off)
+		case r.Method == http.MethodDelete && action == "":
+			h.handleAbort(w, r, off)
 		default:
 			w.WriteHeader(http.StatusNotFound)
 		}
 	}
 }
 
-func (h *Handler) handleSend(w http.ResponseWriter, r *http.Request, off offer) {
-	if off.address != r.RemoteAddr {
+// fail records a failed attempt from a client, logging if it's now locked out.
+func (h *Handler) fail(client string) {
+	if h.failures.fail(client) {
+		h.logger.Log(LevelWarn, "client locked out", "client", client, "duration", h.limits.Lockout)
+		h.metrics.count(&h.metrics.lockouts)
+	}
+}
+
+// handleClaim gives a receiver the sender's key exchange message, and a token with which to join
+// once it has derived the key and can prove it with a key confirmation.
+func (h *Handler) handleClaim(w http.ResponseWriter, r *http.Request, off *offer) {
+	token, err := off.claim(r.Header.Get(exchangeHeader), r.RemoteAddr, h.limits.WrongCodes)
+	if err != nil {
+		h.logger.Log(LevelWarn, "claim refused", "transfer", off.id, "receiver", r.RemoteAddr, "error", err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	h.logger.Log(LevelDebug, "offer claimed", "transfer", off.id, "receiver", r.RemoteAddr)
+	if h.limits.ClaimTimeout > 0 {
+		time.AfterFunc(h.limits.ClaimTimeout, func() {
+			if address, ok := off.expireClaim(token); ok {
+				h.logger.Log(LevelWarn, "claim expired", "transfer", off.id, "receiver", address)
+				h.fail(clientIP(address))
+			}
+		})
+	}
+
+	w.Header().Set(exchangeHeader, off.exchange)
+	w.Header().Set(receiverTokenHeader, token)
+}
+
+// handleHandshake waits for a receiver and passes its key exchange message back to the sender.
+func (h *Handler) handleHandshake(w http.ResponseWriter, r *http.Request, off *offer) {
+	if !off.sentBy(r) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	// wait for a receiver to connect
 	select {
-	case receiveWriter := <-off.receiver:
-		defer off.cancel()
-		if _, err := io.Copy(receiveWriter, r.Body); err != nil {
-			fmt.Fprintln(h.logger, fmt.Errorf("sending file: %w", err))
-			w.WriteHeader(http.StatusInternalServerError)
+	case j := <-off.peer:
+		off.handshake(j)
+		if j.exchange != "" {
+			w.Header().Set(exchangeHeader, j.exchange)
+		}
+		if j.confirmation != "" {
+			w.Header().Set(confirmationHeader, j.confirmation)
+		}
+		if j.offset != "" {
+			w.Header().Set(resumeHeader, j.offset)
+		}
+	case <-off.ctx.Done():
+		w.WriteHeader(off.closedStatus())
+	case <-r.Context().Done():
+	}
+}
+
+// handleReject turns away the receiver the sender just handshook with, because its key confirmation
+// showed it used the wrong code. After too many wrong codes, the offer is destroyed.
+func (h *Handler) handleReject(w http.ResponseWriter, r *http.Request, off *offer) {
+	if !off.sentBy(r) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	j := off.answer()
+	if j == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	rejected := off.reject()
+	h.logger.Log(LevelWarn, "wrong code", "transfer", off.id, "receiver", j.address)
+	h.metrics.count(&h.metrics.wrongCodes)
+	h.fail(clientIP(j.claimant))
+	if clientIP(j.address) != clientIP(j.claimant) {
+		h.fail(clientIP(j.address))
+	}
+
+	if off.dismiss(j) {
+		j.w.WriteHeader(http.StatusForbidden)
+		close(j.done)
+	}
+
+	if h.limits.WrongCodes > 0 && rejected >= h.limits.WrongCodes {
+		h.logger.Log(LevelWarn, "offer destroyed", "transfer", off.id, "wrong_codes", rejected)
+		off.cancel()
+	}
+}
+
+// handleAccept lets the receiver the sender accepted, having seen the metadata, take the offer,
+// so that the sender starts streaming.
+func (h *Handler) handleAccept(w http.ResponseWriter, r *http.Request, off *offer) {
+	if !off.accepts(r.Header.Get(receiverTokenHeader)) {
+		h.fail(clientIP(r.RemoteAddr))
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	select {
+	case off.accept <- struct{}{}:
+	default:
+	}
+}
+
+// handleDecline lets the receiver the sender accepted turn the offer down, once it's seen the metadata.
+// Only a receiver that proved it used the right code is shown the metadata, or may decline.
+// The offer is destroyed, and the sender's requests fail with 409 Conflict.
+func (h *Handler) handleDecline(w http.ResponseWriter, r *http.Request, off *offer) {
+	if !off.accepts(r.Header.Get(receiverTokenHeader)) {
+		h.fail(clientIP(r.RemoteAddr))
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	h.logger.Log(LevelInfo, "offer declined", "transfer", off.id, "receiver", r.RemoteAddr)
+	h.metrics.count(&h.metrics.declines)
+	off.decline()
+}
+
+func (h *Handler) handleSend(w http.ResponseWriter, r *http.Request, off *offer) {
+	if !off.sentBy(r) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// senders that skip the handshake are paired with whichever receiver joins next
+	j := off.answer()
+	if j == nil {
+		select {
+		case j = <-off.peer:
+		case <-off.ctx.Done():
+			w.WriteHeader(off.closedStatus())
+			return
+		case <-r.Context().Done():
 			return
 		}
+	}
+	if !off.pair(j) {
+		// the receiver left between the handshake and now
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+
+	off.meta.writeHeader(j.w.Header())
+	if length, ok := contentLength(off.meta, j.offset); ok {
+		j.w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+	if j.confirms {
+		// show the receiver the metadata, and wait for it to accept or decline before streaming anything,
+		// while the offer's deadline still applies
+		j.answered = true
+		j.w.WriteHeader(http.StatusOK)
+		if f, ok := j.w.(http.Flusher); ok {
+			f.Flush()
+		}
+		select {
+		case <-off.accept:
+		case <-off.ctx.Done():
+		case <-j.ctx.Done():
+			off.abort()
+		case <-r.Context().Done():
+			off.abort()
+		}
+		if off.ctx.Err() != nil {
+			close(j.done)
+			w.WriteHeader(off.closedStatus())
+			return
+		}
+	}
+	off.expireIn(0) // once paired, a transfer can take as long as it needs
+	h.logger.Log(LevelInfo, "transfer started", "transfer", off.id, "receiver", j.address, "offset", j.offset)
+
+	start := time.Now()
+	n, err := h.relay(off, j, r.Body)
+	if err != nil {
+		// the rest of the body will never be read, and may never arrive
+		w.Header().Set("Connection", "close")
+	}
+	if err == errStalled {
+		h.metrics.error(causeStall)
+	}
+	if err != nil && off.ctx.Err() != nil {
+		h.logger.Log(LevelInfo, "transfer stopped", "transfer", off.id, "bytes", n)
+		w.WriteHeader(off.closedStatus())
+		return
+	}
+	if err != nil {
+		h.logger.Log(LevelError, "transfer failed", "transfer", off.id, "bytes", n, "error", err)
+		h.metrics.error(causeTransfer)
+		off.expireIn(resumeTimeout) // give both sides a chance to reconnect and resume
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	h.logger.Log(LevelInfo, "transfer completed", "transfer", off.id, "bytes", n, "duration", time.Since(start))
+	off.cancel()
+}
+
+// relay streams body to the receiver until it ends, the offer is cancelled, the receiver disconnects
+// or the transfer stalls, returning the number of bytes streamed.
+//
+// The sender is read and the receiver written in separate goroutines, joined by a pipe,
+// so that relay can return as soon as the transfer ends, even while one side is blocked on a connection
+// that has gone quiet. The receiver's request finishes once its side has stopped writing.
+func (h *Handler) relay(off *offer, j *join, body io.Reader) (int64, error) {
+	if h.quotas.MaxSize > 0 {
+		body = &sizeLimitedReader{r: body, max: sealedSize(h.quotas.MaxSize, 0)}
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := io.Copy(pw, body)
+		if err == errTooLarge {
+			h.logger.Log(LevelWarn, "transfer too large", "transfer", off.id)
+			off.oversize()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	start := atomic.LoadInt64(&off.moved)
+	stop := make(chan struct{})
+	copied := make(chan error, 1)
+	go func() {
+		w := throttledWriter{countingWriter{j.w, h.metrics, &off.moved}, []*bucket{newBucket(h.quotas.TransferRate), h.rate}, stop}
+		n, err := io.Copy(w, pr)
+		pr.CloseWithError(err) // stop reading the sender if the receiver went away
+		if err != nil && n == 0 && off.ctx.Err() != nil && !j.answered {
+			// nothing has been written yet, so the receiver can still be told why
+			j.w.Header().Del("Content-Length")
+			j.w.WriteHeader(off.closedStatus())
+		}
+		copied <- err
+	}()
+
+	done := h.metrics.transferring()
+	off.stream(true)
 
+	var err error
+	finished := false
+	select {
+	case err = <-copied:
+		finished = true
+	case reason := <-h.stalls.watch(&off.moved, stop):
+		h.logger.Log(LevelWarn, "transfer stalled", "transfer", off.id, "reason", reason)
+		off.stall()
+		err = errStalled
 	case <-off.ctx.Done():
-		w.WriteHeader(http.StatusRequestTimeout)
+		err = off.ctx.Err()
+	case <-j.ctx.Done():
+		err = j.ctx.Err()
+	}
+	close(stop)
+	pw.CloseWithError(err)
+	pr.CloseWithError(err)
+
+	off.stream(false)
+	done(err == nil)
+	if finished {
+		close(j.done)
+	} else {
+		go func() {
+			<-copied
+			close(j.done)
+		}()
+	}
+	return atomic.LoadInt64(&off.moved) - start, err
+}
+
+func (h *Handler) handleReceive(w http.ResponseWriter, r *http.Request, off *offer) {
+	if !h.admit() {
+		h.logger.Log(LevelInfo, "receiver refused", "transfer", off.id, "receiver", r.RemoteAddr, "reason", "too many transfers")
+		h.metrics.count(&h.metrics.refusals)
+		http.Error(w, errTooManyTransfers.Error(), http.StatusTooManyRequests)
 		return
 	}
+	defer h.leave()
+
+	j := &join{
+		confirmation: r.Header.Get(confirmationHeader),
+		offset:       r.Header.Get(resumeHeader),
+		token:        r.Header.Get(receiverTokenHeader),
+		address:      r.RemoteAddr,
+		claimant:     r.RemoteAddr,
+		confirms:     r.Header.Get(confirmsHeader) != "",
+		ctx:          r.Context(),
+		w:            w,
+		done:         make(chan struct{}),
+	}
+
+	// only a receiver that claimed the offer, or is resuming an interrupted transfer, may join
+	c, ok := off.redeem(j.token)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	j.exchange = c.exchange
+	if c.address != "" {
+		j.claimant = c.address
+	}
+
+	h.logger.Log(LevelInfo, "receiver joined", "transfer", off.id, "receiver", j.address, "offset", j.offset)
+
+	select {
+	case off.peer <- j:
+	case <-off.ctx.Done():
+		w.WriteHeader(off.closedStatus())
+		return
+	case <-r.Context().Done():
+		return
+	}
+
+	// wait until the sender has rejected the receiver or streamed the file
+	select {
+	case <-j.done:
+	case <-off.ctx.Done():
+		if off.withdraw(j) {
+			w.WriteHeader(off.closedStatus())
+			return
+		}
+		<-j.done
+	case <-r.Context().Done():
+		if off.withdraw(j) {
+			return
+		}
+		<-j.done
+	}
 }
 
-func (h *Handler) handleReceive(w http.ResponseWriter, r *http.Request, off offer) {
-	w.Header().Add(filenameHeader, off.filename)
-	off.receiver <- w
-	<-off.ctx.Done() // wait until h.handleSend is complete
+// handleAbort lets either side abandon a transfer, promptly failing the other side's requests.
+// Each side identifies itself with its token.
+func (h *Handler) handleAbort(w http.ResponseWriter, r *http.Request, off *offer) {
+	if !off.sentBy(r) && !off.holds(r.Header.Get(receiverTokenHeader)) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	h.logger.Log(LevelInfo, "transfer aborted", "transfer", off.id, "by", r.RemoteAddr)
+	h.metrics.error(causeAbort)
+	off.abort()
 }
 
-func (h *Handler) createOffer(filename, address string) (secret string, err error) {
+// Shutdown gracefully stops the handler: new offers are refused with 503 Service Unavailable,
+// while existing offers can still be received and their transfers run to completion.
+// If ctx is done first, the remaining offers are cancelled, failing their requests with 503,
+// and ctx's error is returned.
+//
+// Shutdown doesn't stop the HTTP server; call it before the server's own Shutdown.
+func (h *Handler) Shutdown(ctx context.Context) error {
+	h.Lock()
+	h.draining = true
+	remaining := len(h.offers)
+	h.Unlock()
+	h.logger.Log(LevelInfo, "draining", "offers", remaining)
+
+	drained := make(chan struct{})
+	go func() {
+		h.active.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+	}
+
+	h.RLock()
+	h.logger.Log(LevelWarn, "drain deadline passed", "offers", len(h.offers))
+	for _, off := range h.offers {
+		off.stop()
+	}
+	h.RUnlock()
+
+	<-drained
+	return ctx.Err()
+}
+
+// offerLifetime is how long an offer lasts when its sender asks for it to expire in the given number of seconds:
+// the handler's default if it didn't ask, or at most the handler's maximum.
+func (h *Handler) offerLifetime(requested string) time.Duration {
+	seconds, err := strconv.ParseInt(requested, 10, 64)
+	if err != nil || seconds <= 0 {
+		return h.lifetime
+	}
+	if seconds > int64(h.maxLife/time.Second) {
+		return h.maxLife
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func (h *Handler) createOffer(meta Metadata, exchange, address string, lifetime time.Duration) (secret string, off *offer, err error) {
+	id, err := newToken()
+	if err != nil {
+		return "", nil, fmt.Errorf("generating transfer id: %w", err)
+	}
+	token, err := newToken()
+	if err != nil {
+		return "", nil, fmt.Errorf("generating sender token: %w", err)
+	}
+
 	h.Lock()
 	defer h.Unlock()
 
-	ctx, cancel := context.WithTimeout(context.Background(), offerTimeout)
+	if h.draining {
+		return "", nil, errDraining
+	}
+	ip := clientIP(address)
+	if h.quotas.OffersPerIP > 0 && h.senders[ip] >= h.quotas.OffersPerIP {
+		return "", nil, errTooManyOffers
+	}
 
-	off := offer{
-		filename: filename,
+	ctx, cancel := context.WithCancel(context.Background())
+
+	off = &offer{
+		id:       id[:12],
+		token:    token,
+		created:  time.Now(),
+		meta:     meta,
 		address:  address,
-		receiver: make(chan http.ResponseWriter),
+		exchange: exchange,
+		claims:   make(map[string]claim),
+		peer:     make(chan *join),
+		accept:   make(chan struct{}, 1),
 		ctx:      ctx,
 		cancel:   cancel,
 	}
+	off.expireIn(lifetime)
 
-	// ensure secret is unique
-	for exists := true; exists; {
-		secret = h.secrets.String()
-		_, exists = h.offers[secret]
+	// ensure secret is unique, across every node sharing the store
+	for attempt := 0; ; attempt++ {
+		if attempt == maxSecretAttempts {
+			cancel()
+			return "", nil, errNoSecrets
+		}
+		secret = h.nextSecret(attempt)
+		err = h.cluster.Store.Reserve(secret, h.cluster.Self)
+		if err == nil {
+			break
+		}
+		if err != ErrSecretTaken {
+			cancel()
+			return "", nil, err
+		}
 	}
 
 	h.offers[secret] = off
+	delete(h.ended, secret)
+	h.senders[ip]++
+	h.active.Add(1)
 
 	// destroy the offer once it's completed
 	go func() {
+		defer h.active.Done()
 		<-off.ctx.Done()
 		h.Lock()
-		defer h.Unlock()
 		delete(h.offers, secret)
+		if h.senders[ip]--; h.senders[ip] == 0 {
+			delete(h.senders, ip)
+		}
+		h.Unlock()
+		if off.timedOut() {
+			h.logger.Log(LevelWarn, "offer timed out", "transfer", off.id)
+			h.metrics.count(&h.metrics.timeouts)
+		}
+		if status := off.closedStatus(); status != http.StatusRequestTimeout {
+			h.remember(secret, status)
+		}
+		if err := h.cluster.Store.Release(secret); err != nil {
+			h.logger.Log(LevelError, "releasing offer failed", "transfer", off.id, "error", err)
+			h.metrics.error(causeStore)
+		}
 	}()
 
-	return secret, nil
+	return secret, off, nil
+}
+
+// crowdedSecrets is implemented by secret generators, like Secrets, that draw from a larger space
+// once the usual one is crowded with offers.
+type crowdedSecrets interface {
+	Crowded(collisions int) string
+}
+
+// nextSecret returns a secret to try for a new offer, after attempt secrets in a row were taken.
+func (h *Handler) nextSecret(attempt int) string {
+	if crowded, ok := h.secrets.(crowdedSecrets); ok {
+		return crowded.Crowded(attempt)
+	}
+	return h.secrets.String()
 }
 
-func (h *Handler) findOffer(secret string) (offer, error) {
+func (h *Handler) findOffer(secret string) (*offer, error) {
 	h.Lock()
 	defer h.Unlock()
 
 	off, ok := h.offers[secret]
 	if !ok {
-		return offer{}, fmt.Errorf("no such secret: %v", secret)
+		return nil, errors.New("no such secret")
 	}
 
 	return off, nil
 }
+
+// remember keeps the status of an offer that was declined, aborted, stalled or stopped for as long as its clients
+// might retry, so that they learn why it ended rather than finding no such secret.
+func (h *Handler) remember(secret string, status int) {
+	h.Lock()
+	h.ended[secret] = status
+	h.Unlock()
+
+	time.AfterFunc(resumeTimeout, func() {
+		h.Lock()
+		defer h.Unlock()
+		if h.ended[secret] == status {
+			delete(h.ended, secret)
+		}
+	})
+}
+
+// endedStatus returns the status of a recently ended offer.
+func (h *Handler) endedStatus(secret string) (int, bool) {
+	h.RLock()
+	defer h.RUnlock()
+	status, ok := h.ended[secret]
+	return status, ok
+}
+
+// contentLength returns the length of the encrypted stream that the receiver will be sent,
+// if the sender advertised the file's size.
+func contentLength(meta Metadata, offset string) (int64, bool) {
+	if meta.Size < 0 {
+		return 0, false
+	}
+	from := int64(0)
+	if offset != "" {
+		var err error
+		if from, err = strconv.ParseInt(offset, 10, 64); err != nil || from < 0 || from%chunkSize != 0 || from > meta.Size {
+			return 0, false
+		}
+	}
+	return sealedSize(meta.Size, from), true
+}
+
+// clientIP returns the host of a remote address, by which clients are rate limited.
+func clientIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// newToken returns an unguessable random string.
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
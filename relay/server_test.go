@@ -1,14 +1,19 @@
 package relay
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // test utilities
@@ -53,6 +58,24 @@ func (cw *countWriter) WriteString(str string) (int, error) {
 	return n, nil
 }
 
+// post makes an offer, returning the token that authorizes the sender's requests.
+func post(handler http.Handler, request *http.Request) (token string) {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, request)
+	return w.Header().Get(senderTokenHeader)
+}
+
+// receiveRequest claims the offer at secret, returning a request with which to join it as the receiver.
+func receiveRequest(handler http.Handler, secret string) *http.Request {
+	claim, _ := http.NewRequest(http.MethodPost, "/file/"+secret+"/claim", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, claim)
+
+	request, _ := http.NewRequest(http.MethodGet, "/file/"+secret, nil)
+	request.Header.Set(receiverTokenHeader, w.Header().Get(receiverTokenHeader))
+	return request
+}
+
 type genReader struct {
 	remaining int
 }
@@ -83,6 +106,7 @@ func TestHandlerGoldenPath(t *testing.T) {
 	const secret = "some-secret-string"
 
 	handler := NewHandler(newSecretList(secret), ioutil.Discard)
+	var token string
 
 	t.Run("POST returns a secret code", func(t *testing.T) {
 		request, _ := http.NewRequest(http.MethodPost, "/file", nil)
@@ -91,6 +115,7 @@ func TestHandlerGoldenPath(t *testing.T) {
 
 		handler.ServeHTTP(w, request)
 		resp := w.Result()
+		token = resp.Header.Get(senderTokenHeader)
 
 		got, _ := ioutil.ReadAll(resp.Body)
 		want := secret + "\n"
@@ -103,13 +128,14 @@ func TestHandlerGoldenPath(t *testing.T) {
 	go func() {
 		file := strings.NewReader(contents)
 		request, _ := http.NewRequest(http.MethodPut, "/file/"+secret, file)
+		request.Header.Set(senderTokenHeader, token)
 		w := httptest.NewRecorder()
 
 		handler.ServeHTTP(w, request)
 	}()
 
 	t.Run("GET receives a file", func(t *testing.T) {
-		request, _ := http.NewRequest(http.MethodGet, "/file/"+secret, nil)
+		request := receiveRequest(handler, secret)
 		w := httptest.NewRecorder()
 
 		handler.ServeHTTP(w, request)
@@ -158,6 +184,7 @@ func TestHandlerLargeFile(t *testing.T) {
 
 		file := &genReader{size}
 		req2, _ := http.NewRequest(http.MethodPut, "/file/"+secret, file)
+		req2.Header.Set(senderTokenHeader, w.Header().Get(senderTokenHeader))
 		w2 := newCountWriter()
 		handler.ServeHTTP(w2, req2)
 	}()
@@ -165,7 +192,7 @@ func TestHandlerLargeFile(t *testing.T) {
 	<-offered
 
 	t.Run("receives 1 GB of data", func(t *testing.T) {
-		request, _ := http.NewRequest(http.MethodGet, "/file/"+secret, nil)
+		request := receiveRequest(handler, secret)
 		writer := newCountWriter()
 		handler.ServeHTTP(writer, request)
 
@@ -200,12 +227,13 @@ func TestHandlerSimultaneous(t *testing.T) {
 
 		file := strings.NewReader(fmt.Sprintf("file contents %v", i))
 		req2, _ := http.NewRequest(http.MethodPut, "/file/"+secrets[i], file)
+		req2.Header.Set(senderTokenHeader, w.Header().Get(senderTokenHeader))
 		w2 := httptest.NewRecorder()
 		go handler.ServeHTTP(w2, req2)
 	}
 
 	for i := 0; i < len(secrets); i++ {
-		request, _ := http.NewRequest(http.MethodGet, "/file/"+secrets[i], nil)
+		request := receiveRequest(handler, secrets[i])
 		w := httptest.NewRecorder()
 
 		handler.ServeHTTP(w, request)
@@ -246,6 +274,7 @@ func TestHandlerWrongSecret(t *testing.T) {
 
 		file := strings.NewReader(contents)
 		req2, _ := http.NewRequest(http.MethodPut, "/file/"+secret, file)
+		req2.Header.Set(senderTokenHeader, w.Header().Get(senderTokenHeader))
 		w2 := httptest.NewRecorder()
 		handler.ServeHTTP(w2, req2)
 	}()
@@ -277,3 +306,781 @@ func TestHandlerWrongSecret(t *testing.T) {
 		}
 	})
 }
+
+func TestHandlerResumeToken(t *testing.T) {
+	const secret = "some-secret-string"
+
+	handler := NewHandler(newSecretList(secret), ioutil.Discard)
+
+	request, _ := http.NewRequest(http.MethodPost, "/file", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	request, _ = http.NewRequest(http.MethodGet, "/file/"+secret, nil)
+	request.Header.Set(receiverTokenHeader, "guessed-token")
+	request.Header.Set(resumeHeader, "0")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, request)
+
+	t.Run("rejects resuming without the receiver's token", func(t *testing.T) {
+		got := w.Code
+		want := http.StatusNotFound
+
+		if got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestHandlerLockout(t *testing.T) {
+	const secret = "some-secret-string"
+	const attacker = "198.51.100.7:1234"
+
+	limits := Limits{LookupFailures: 3, FailureWindow: time.Minute, Lockout: time.Minute}
+	handler := NewHandler(newSecretList(secret), ioutil.Discard, WithLimits(limits))
+
+	request, _ := http.NewRequest(http.MethodPost, "/file", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	claim := func(path, addr string) int {
+		request, _ := http.NewRequest(http.MethodPost, path, nil)
+		request.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		return w.Code
+	}
+
+	for i := 0; i < limits.LookupFailures; i++ {
+		if got := claim("/file/wrong-secret/claim", attacker); got != http.StatusNotFound {
+			t.Fatalf("guess %v: got %v, want %v", i, got, http.StatusNotFound)
+		}
+	}
+
+	t.Run("locks out the guessing IP", func(t *testing.T) {
+		got := claim("/file/"+secret+"/claim", attacker)
+		want := http.StatusTooManyRequests
+
+		if got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("serves other IPs", func(t *testing.T) {
+		got := claim("/file/"+secret+"/claim", "203.0.113.9:1234")
+		want := http.StatusOK
+
+		if got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestHandlerUnclaimedJoin(t *testing.T) {
+	const secret = "some-secret-string"
+
+	handler := NewHandler(newSecretList(secret), ioutil.Discard)
+	request, _ := http.NewRequest(http.MethodPost, "/file", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	t.Run("refuses receivers without a claim", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/file/"+secret, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+
+		if got, want := w.Code, http.StatusNotFound; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("leaves the offer to be claimed", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodPost, "/file/"+secret+"/claim", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+
+		if got, want := w.Code, http.StatusOK; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestHandlerClaimTimeout(t *testing.T) {
+	const secret = "some-secret-string"
+	const attacker = "198.51.100.7:1234"
+
+	limits := Limits{LookupFailures: 2, FailureWindow: time.Minute, Lockout: time.Minute, WrongCodes: 1, ClaimTimeout: 10 * time.Millisecond}
+	handler := NewHandler(newSecretList(secret), ioutil.Discard, WithLimits(limits))
+
+	request, _ := http.NewRequest(http.MethodPost, "/file", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	claim := func(addr string) int {
+		request, _ := http.NewRequest(http.MethodPost, "/file/"+secret+"/claim", nil)
+		request.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		return w.Code
+	}
+
+	t.Run("frees the offer for another claim", func(t *testing.T) {
+		if got := claim(attacker); got != http.StatusOK {
+			t.Fatalf("got %v, want %v", got, http.StatusOK)
+		}
+		if got := claim("203.0.113.9:1234"); got != http.StatusNotFound {
+			t.Fatalf("claimed twice: got %v, want %v", got, http.StatusNotFound)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if got := claim(attacker); got != http.StatusOK {
+			t.Errorf("got %v, want %v", got, http.StatusOK)
+		}
+	})
+
+	t.Run("locks out IPs that never redeem their claims", func(t *testing.T) {
+		time.Sleep(50 * time.Millisecond)
+		if got := claim(attacker); got != http.StatusTooManyRequests {
+			t.Errorf("got %v, want %v", got, http.StatusTooManyRequests)
+		}
+	})
+}
+
+func TestHandlerContentLength(t *testing.T) {
+	const secret = "some-secret-string"
+	const size = 3*chunkSize + 17
+
+	handler := NewHandler(newSecretList(secret), ioutil.Discard)
+
+	request, _ := http.NewRequest(http.MethodPost, "/file", nil)
+	request.Header.Set(sizeHeader, fmt.Sprint(size))
+	token := post(handler, request)
+
+	go func() {
+		sealed := &genReader{remaining: int(sealedSize(size, 0))}
+		request, _ := http.NewRequest(http.MethodPut, "/file/"+secret, sealed)
+		request.Header.Set(senderTokenHeader, token)
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+	}()
+
+	request = receiveRequest(handler, secret)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, request)
+
+	t.Run("sets the length of the encrypted stream", func(t *testing.T) {
+		got := w.Header().Get("Content-Length")
+		want := fmt.Sprint(sealedSize(size, 0))
+
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if w.Body.Len() != int(sealedSize(size, 0)) {
+			t.Errorf("got %v bytes, want %v", w.Body.Len(), want)
+		}
+	})
+
+	t.Run("advertises the size", func(t *testing.T) {
+		got := w.Header().Get(sizeHeader)
+		want := fmt.Sprint(size)
+
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestHandlerMetrics(t *testing.T) {
+	const secret = "some-secret-string"
+	const size = 1234
+
+	handler := NewHandler(newSecretList(secret), ioutil.Discard)
+
+	request, _ := http.NewRequest(http.MethodPost, "/file", nil)
+	token := post(handler, request)
+
+	go func() {
+		request, _ := http.NewRequest(http.MethodPut, "/file/"+secret, &genReader{remaining: size})
+		request.Header.Set(senderTokenHeader, token)
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+	}()
+
+	request = receiveRequest(handler, secret)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	request, _ = http.NewRequest(http.MethodGet, "/file/wrong-secret", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	request, _ = http.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, request)
+	body := w.Body.String()
+
+	tests := []string{
+		"# TYPE relay_bytes_relayed_total counter\n",
+		fmt.Sprintf("relay_bytes_relayed_total %v\n", size),
+		"relay_transfers_in_flight 0\n",
+		"relay_transfer_duration_seconds_count 1\n",
+		"relay_transfer_duration_seconds_bucket{le=\"+Inf\"} 1\n",
+		"relay_wrong_secret_lookups_total 1\n",
+		"relay_errors_total{cause=\"transfer\"} 0\n",
+	}
+	for _, want := range tests {
+		t.Run(strings.TrimSpace(want), func(t *testing.T) {
+			if !strings.Contains(body, want) {
+				t.Errorf("missing %q in:\n%v", want, body)
+			}
+		})
+	}
+}
+
+// eventLog records logged events as logfmt lines.
+type eventLog struct {
+	sync.Mutex
+	events []string
+}
+
+func (el *eventLog) Log(level Level, event string, keyvals ...interface{}) {
+	var buf bytes.Buffer
+	encodeLogfmt(&buf, append([]interface{}{"event", event}, keyvals...))
+
+	el.Lock()
+	defer el.Unlock()
+	el.events = append(el.events, buf.String())
+}
+
+func TestHandlerLogs(t *testing.T) {
+	const secret = "some-secret-string"
+
+	events := &eventLog{}
+	handler := NewHandler(newSecretList(secret), ioutil.Discard, WithLogger(events))
+
+	request, _ := http.NewRequest(http.MethodPost, "/file", nil)
+	token := post(handler, request)
+
+	sent := make(chan struct{})
+	go func() {
+		request, _ := http.NewRequest(http.MethodPut, "/file/"+secret, strings.NewReader("file contents"))
+		request.Header.Set(senderTokenHeader, token)
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+		close(sent)
+	}()
+
+	request = receiveRequest(handler, secret)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+	<-sent
+
+	request, _ = http.NewRequest(http.MethodGet, "/file/some-secret-strinG", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	events.Lock()
+	defer events.Unlock()
+
+	t.Run("logs the lifecycle with one transfer id", func(t *testing.T) {
+		want := []string{"offer created", "offer claimed", "receiver joined", "transfer started", "transfer completed"}
+		if len(events.events) < len(want) {
+			t.Fatalf("got %v events, want at least %v", len(events.events), len(want))
+		}
+		id := events.events[0][strings.Index(events.events[0], "transfer="):]
+		id = strings.Fields(id)[0]
+
+		for i, event := range want {
+			got := events.events[i]
+			if !strings.Contains(got, fmt.Sprintf("event=%q", event)) || !strings.Contains(got, id+" ") {
+				t.Errorf("got %v, want %q for %v", got, event, id)
+			}
+		}
+		if got := events.events[4]; !strings.Contains(got, "bytes=13") {
+			t.Errorf("got %v, want bytes=13", got)
+		}
+	})
+
+	t.Run("never logs secrets", func(t *testing.T) {
+		for _, event := range events.events {
+			if strings.Contains(strings.ToLower(event), secret) {
+				t.Errorf("secret in %v", event)
+			}
+		}
+	})
+}
+
+func TestHandlerShutdown(t *testing.T) {
+	const secret = "some-secret-string"
+
+	t.Run("refuses new offers", func(t *testing.T) {
+		handler := NewHandler(newSecretList(secret), ioutil.Discard)
+		if err := handler.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		request, _ := http.NewRequest(http.MethodPost, "/file", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+
+		if got, want := w.Code, http.StatusServiceUnavailable; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("lets transfers finish", func(t *testing.T) {
+		handler := NewHandler(newSecretList(secret), ioutil.Discard)
+		request, _ := http.NewRequest(http.MethodPost, "/file", nil)
+		token := post(handler, request)
+
+		shutdown := make(chan error)
+		go func() {
+			shutdown <- handler.Shutdown(context.Background())
+		}()
+
+		go func() {
+			request, _ := http.NewRequest(http.MethodPut, "/file/"+secret, strings.NewReader("file contents"))
+			request.Header.Set(senderTokenHeader, token)
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+		}()
+
+		request = receiveRequest(handler, secret)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+
+		if got, want := w.Body.String(), "file contents"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		select {
+		case err := <-shutdown:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("still draining after the transfer completed")
+		}
+	})
+
+	t.Run("cancels offers at the deadline", func(t *testing.T) {
+		handler := NewHandler(newSecretList(secret), ioutil.Discard)
+		request, _ := http.NewRequest(http.MethodPost, "/file", nil)
+		token := post(handler, request)
+
+		sent := make(chan int)
+		go func() {
+			request, _ := http.NewRequest(http.MethodPut, "/file/"+secret, strings.NewReader("file contents"))
+			request.Header.Set(senderTokenHeader, token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+			sent <- w.Code
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if err := handler.Shutdown(ctx); err != context.DeadlineExceeded {
+			t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+		}
+		if got, want := <-sent, http.StatusServiceUnavailable; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestHandlerSenderToken(t *testing.T) {
+	const secret = "some-secret-string"
+
+	handler := NewHandler(newSecretList(secret), ioutil.Discard)
+
+	request, _ := http.NewRequest(http.MethodPost, "/file", nil)
+	request.RemoteAddr = "192.0.2.1:1234"
+	token := post(handler, request)
+
+	t.Run("POST returns a token", func(t *testing.T) {
+		if len(token) < 32 {
+			t.Errorf("got %q, want an unguessable token", token)
+		}
+	})
+
+	t.Run("refuses senders without the token", func(t *testing.T) {
+		for _, guess := range []string{"", "guessed-token"} {
+			request, _ := http.NewRequest(http.MethodPut, "/file/"+secret, strings.NewReader("file contents"))
+			request.RemoteAddr = "192.0.2.1:1234" // even from the sender's address
+			request.Header.Set(senderTokenHeader, guess)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+
+			if got, want := w.Code, http.StatusNotFound; got != want {
+				t.Errorf("got %v with %q, want %v", got, guess, want)
+			}
+		}
+	})
+
+	t.Run("accepts the token from any address", func(t *testing.T) {
+		go func() {
+			request, _ := http.NewRequest(http.MethodPut, "/file/"+secret, strings.NewReader("file contents"))
+			request.RemoteAddr = "198.51.100.7:5678"
+			request.Header.Set(senderTokenHeader, token)
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+		}()
+
+		request := receiveRequest(handler, secret)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+
+		if got, want := w.Body.String(), "file contents"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
+
+func TestHandlerDecline(t *testing.T) {
+	// each offer's premature decline counts as a failure, but a decline by the accepted receiver mustn't,
+	// or the second offer would be locked out
+	limits := Limits{LookupFailures: 3, FailureWindow: time.Minute, Lockout: time.Minute}
+	handler := NewHandler(newSecretList("a-a-a", "b-b-b"), ioutil.Discard, WithLimits(limits))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	do := func(method, path, header, value string, body io.Reader) (*http.Response, error) {
+		request, _ := http.NewRequest(method, server.URL+path, body)
+		request.Header.Set(header, value)
+		request.Header.Set(confirmsHeader, "true")
+		return http.DefaultClient.Do(request)
+	}
+	status := func(method, path, header, value string) int {
+		resp, err := do(method, path, header, value, nil)
+		if err != nil {
+			t.Error(err)
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// offer makes an offer, which the sender accepts a receiver for, returning the receiver's token,
+	// the receiver's response, with the metadata but no body yet, and the status of the sender's PUT
+	offer := func(secret string) (receiver string, received *http.Response, sent chan int) {
+		request, _ := http.NewRequest(http.MethodPost, "/file", nil)
+		request.Header.Set(filenameHeader, "file.txt")
+		token := post(handler, request)
+
+		resp, err := do(http.MethodPost, "/file/"+secret+"/claim", exchangeHeader, "receiver-exchange", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		receiver = resp.Header.Get(receiverTokenHeader)
+		if resp.Header.Get(filenameHeader) != "" {
+			t.Error("claim revealed the metadata")
+		}
+		if got, want := status(http.MethodPost, "/file/"+secret+"/decline", receiverTokenHeader, receiver), http.StatusNotFound; got != want {
+			t.Errorf("declined before the sender accepted: got %v, want %v", got, want)
+		}
+
+		sent = make(chan int, 1)
+		go func() {
+			status(http.MethodGet, "/file/"+secret+"/handshake", senderTokenHeader, token)
+			resp, err := do(http.MethodPut, "/file/"+secret, senderTokenHeader, token, strings.NewReader("contents"))
+			if err != nil {
+				t.Error(err)
+				sent <- 0
+				return
+			}
+			resp.Body.Close()
+			sent <- resp.StatusCode
+		}()
+
+		received, err = do(http.MethodGet, "/file/"+secret, receiverTokenHeader, receiver, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := received.Header.Get(filenameHeader), "file.txt"; got != want {
+			t.Errorf("got metadata %q, want %q", got, want)
+		}
+		return receiver, received, sent
+	}
+
+	t.Run("fails the sender", func(t *testing.T) {
+		receiver, received, sent := offer("a-a-a")
+		defer received.Body.Close()
+
+		if got, want := status(http.MethodPost, "/file/a-a-a/decline", receiverTokenHeader, receiver), http.StatusOK; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		if got, want := <-sent, http.StatusConflict; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("streams once accepted", func(t *testing.T) {
+		receiver, received, sent := offer("b-b-b")
+		defer received.Body.Close()
+
+		if got, want := status(http.MethodPost, "/file/b-b-b/accept", receiverTokenHeader, receiver), http.StatusOK; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+		body, _ := ioutil.ReadAll(received.Body)
+		if got, want := string(body), "contents"; got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if got, want := <-sent, http.StatusOK; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestHandlerOfferLifetime(t *testing.T) {
+	handler := NewHandler(newSecretList("a-a-a", "b-b-b", "c-c-c"), ioutil.Discard, WithOfferLifetime(10*time.Minute, time.Hour))
+
+	expires := func(requested string) time.Duration {
+		request, _ := http.NewRequest(http.MethodPost, "/file", nil)
+		if requested != "" {
+			request.Header.Set(expiresInHeader, requested)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+
+		expires, err := time.Parse(time.RFC3339, w.Header().Get(expiresHeader))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return time.Until(expires).Round(time.Minute)
+	}
+
+	tests := []struct {
+		name      string
+		requested string
+		want      time.Duration
+	}{
+		{"defaults", "", 10 * time.Minute},
+		{"lets senders choose", "120", 2 * time.Minute},
+		{"caps senders' choice", "7200", time.Hour},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := expires(test.requested); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	t.Run("ignores lifetimes that never expire", func(t *testing.T) {
+		handler := NewHandler(newSecretList("a-a-a"), ioutil.Discard, WithOfferLifetime(0, -time.Hour))
+		if got, want := handler.lifetime, offerTimeout; got != want {
+			t.Errorf("got lifetime %v, want %v", got, want)
+		}
+		if got, want := handler.maxLife, maxOfferTimeout; got != want {
+			t.Errorf("got max lifetime %v, want %v", got, want)
+		}
+	})
+
+	t.Run("expires offers", func(t *testing.T) {
+		handler := NewHandler(newSecretList("a-a-a"), ioutil.Discard, WithOfferLifetime(50*time.Millisecond, time.Hour))
+		request, _ := http.NewRequest(http.MethodPost, "/file", nil)
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+		time.Sleep(200 * time.Millisecond)
+
+		request = receiveRequest(handler, "a-a-a")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+
+		if got, want := w.Code, http.StatusNotFound; got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+// stallReader yields size bytes, then blocks until release is closed.
+type stallReader struct {
+	genReader
+	release chan struct{}
+}
+
+func (sr *stallReader) Read(p []byte) (int, error) {
+	if sr.remaining <= 0 {
+		<-sr.release
+		return 0, io.EOF
+	}
+	return sr.genReader.Read(p)
+}
+
+// trickleReader yields a byte every delay, until release is closed.
+type trickleReader struct {
+	delay   time.Duration
+	release chan struct{}
+}
+
+func (tr *trickleReader) Read(p []byte) (int, error) {
+	select {
+	case <-time.After(tr.delay):
+		p[0] = 'X'
+		return 1, nil
+	case <-tr.release:
+		return 0, io.EOF
+	}
+}
+
+// stallWriter is a ResponseWriter whose Write blocks until release is closed.
+type stallWriter struct {
+	header  http.Header
+	release chan struct{}
+}
+
+func (sw *stallWriter) Header() http.Header {
+	return sw.header
+}
+
+func (sw *stallWriter) WriteHeader(int) {}
+
+func (sw *stallWriter) Write(p []byte) (int, error) {
+	<-sw.release
+	return 0, io.ErrClosedPipe
+}
+
+func TestHandlerStall(t *testing.T) {
+	const secret = "some-secret-string"
+
+	// send streams body to whichever receiver joins handler, returning the sender's response
+	send := func(handler *Handler, body io.Reader) <-chan *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(http.MethodPost, "/file", nil)
+		token := post(handler, request)
+
+		sent := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			request, _ := http.NewRequest(http.MethodPut, "/file/"+secret, body)
+			request.Header.Set(senderTokenHeader, token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+			sent <- w
+		}()
+		return sent
+	}
+	receive := func(handler *Handler) *httptest.ResponseRecorder {
+		request := receiveRequest(handler, secret)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		return w
+	}
+	// stalled checks that the sender was told its transfer stalled, without waiting for its body to end
+	stalled := func(t *testing.T, sent <-chan *httptest.ResponseRecorder) {
+		t.Helper()
+		select {
+		case w := <-sent:
+			if got, want := w.Code, http.StatusGatewayTimeout; got != want {
+				t.Errorf("sender got %v, want %v", got, want)
+			}
+			if got, want := w.Header().Get("Connection"), "close"; got != want {
+				t.Errorf("sender got Connection %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("sender is still sending")
+		}
+	}
+
+	t.Run("idle before the first byte", func(t *testing.T) {
+		handler := NewHandler(newSecretList(secret), ioutil.Discard, WithStallLimits(StallLimits{Idle: 100 * time.Millisecond}))
+		release := make(chan struct{})
+		defer close(release)
+		sent := send(handler, &stallReader{release: release})
+
+		w := receive(handler)
+		if got, want := w.Code, http.StatusGatewayTimeout; got != want {
+			t.Errorf("receiver got %v, want %v", got, want)
+		}
+		stalled(t, sent)
+	})
+
+	t.Run("idle midway", func(t *testing.T) {
+		handler := NewHandler(newSecretList(secret), ioutil.Discard, WithStallLimits(StallLimits{Idle: 100 * time.Millisecond}))
+		release := make(chan struct{})
+		defer close(release)
+		sent := send(handler, &stallReader{genReader{remaining: 5000}, release})
+
+		if got, want := receive(handler).Body.Len(), 5000; got != want {
+			t.Errorf("receiver got %v bytes, want %v", got, want)
+		}
+		stalled(t, sent)
+
+		t.Run("tells a resuming receiver why", func(t *testing.T) {
+			if got, want := receive(handler).Code, http.StatusGatewayTimeout; got != want {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+
+		t.Run("counts the stall", func(t *testing.T) {
+			request, _ := http.NewRequest(http.MethodGet, "/metrics", nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, request)
+			if want := "relay_errors_total{cause=\"stall\"} 1\n"; !strings.Contains(w.Body.String(), want) {
+				t.Errorf("missing %q in:\n%v", want, w.Body.String())
+			}
+		})
+	})
+
+	t.Run("below the minimum rate", func(t *testing.T) {
+		limits := StallLimits{Idle: time.Minute, MinRate: 1 << 20, Window: 100 * time.Millisecond}
+		handler := NewHandler(newSecretList(secret), ioutil.Discard, WithStallLimits(limits))
+		release := make(chan struct{})
+		defer close(release)
+		sent := send(handler, &trickleReader{delay: 5 * time.Millisecond, release: release})
+
+		receive(handler)
+		stalled(t, sent)
+	})
+
+	t.Run("stalled receiver", func(t *testing.T) {
+		handler := NewHandler(newSecretList(secret), ioutil.Discard, WithStallLimits(StallLimits{Idle: 100 * time.Millisecond}))
+		sent := send(handler, &genReader{remaining: 5000})
+
+		release := make(chan struct{})
+		received := make(chan struct{})
+		go func() {
+			request := receiveRequest(handler, secret)
+			handler.ServeHTTP(&stallWriter{header: make(http.Header), release: release}, request)
+			close(received)
+		}()
+
+		stalled(t, sent)
+		close(release)
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Error("receiver is still receiving")
+		}
+	})
+
+	t.Run("steady transfers", func(t *testing.T) {
+		limits := StallLimits{Idle: 100 * time.Millisecond, MinRate: 100, Window: 100 * time.Millisecond}
+		handler := NewHandler(newSecretList(secret), ioutil.Discard, WithStallLimits(limits))
+		release := make(chan struct{})
+		sent := send(handler, &trickleReader{delay: 5 * time.Millisecond, release: release})
+		time.AfterFunc(500*time.Millisecond, func() { close(release) })
+
+		if got := receive(handler).Code; got != http.StatusOK {
+			t.Errorf("receiver got %v, want %v", got, http.StatusOK)
+		}
+		if got := (<-sent).Code; got != http.StatusOK {
+			t.Errorf("sender got %v, want %v", got, http.StatusOK)
+		}
+	})
+}
+
+func TestHandlerSecretsExhausted(t *testing.T) {
+	offer := func(handler *Handler) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(http.MethodPost, "/file", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		return w
+	}
+
+	t.Run("widens the range of numbers", func(t *testing.T) {
+		secrets, _ := NewSecrets(WithNumbers(1))
+		handler := NewHandler(secrets, ioutil.Discard)
+
+		if got, want := strings.TrimSpace(offer(handler).Body.String()), "1"; got != want {
+			t.Errorf("got %q for the first offer, want %q", got, want)
+		}
+		for i := 2; i <= 3; i++ {
+			if got, want := offer(handler).Code, http.StatusOK; got != want {
+				t.Errorf("got %v for offer %v, want %v", got, i, want)
+			}
+		}
+	})
+
+	t.Run("refuses offers when every secret is taken", func(t *testing.T) {
+		handler := NewHandler(newSecretList("a-a-a"), ioutil.Discard)
+
+		codes := []int{offer(handler).Code, offer(handler).Code}
+		if got, want := codes, []int{http.StatusOK, http.StatusServiceUnavailable}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
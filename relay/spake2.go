@@ -0,0 +1,198 @@
+package relay
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+)
+
+// The SPAKE2 exchange runs in the prime-order subgroup of quadratic residues
+// of the 2048-bit MODP group from RFC 3526.
+var (
+	groupP = mustParseHex(`
+		FFFFFFFF FFFFFFFF C90FDAA2 2168C234 C4C6628B 80DC1CD1
+		29024E08 8A67CC74 020BBEA6 3B139B22 514A0879 8E3404DD
+		EF9519B3 CD3A431B 302B0A6D F25F1437 4FE1356D 6D51C245
+		E485B576 625E7EC6 F44C42E9 A637ED6B 0BFF5CB6 F406B7ED
+		EE386BFB 5A899FA5 AE9F2411 7C4B1FE6 49286651 ECE45B3D
+		C2007CB8 A163BF05 98DA4836 1C55D39A 69163FA8 FD24CF5F
+		83655D23 DCA3AD96 1C62F356 208552BB 9ED52907 7096966D
+		670C354E 4ABC9804 F1746C08 CA18217C 32905E46 2E36CE3B
+		E39E772C 180E8603 9B2783A2 EC07A28F B5C55DF0 6F4C52C9
+		DE2BCBF6 95581718 3995497C EA956AE5 15D22618 98FA0510
+		15728E5A 8AACAA68 FFFFFFFF FFFFFFFF`)
+	groupQ = new(big.Int).Rsh(groupP, 1)
+	groupG = big.NewInt(4)
+	groupM = hashToGroup("storj relay spake2 M")
+	groupN = hashToGroup("storj relay spake2 N")
+
+	elementSize = (groupP.BitLen() + 7) / 8
+)
+
+var errBadExchange = errors.New("invalid key exchange message")
+
+type spakeRole byte
+
+const (
+	spakeSender   spakeRole = 'A'
+	spakeReceiver spakeRole = 'B'
+)
+
+// spake2 is one side of a SPAKE2 password-authenticated key exchange.
+//
+// Each side sends a single message; an eavesdropper (or the relay) learns nothing
+// about the password from them, and an active attacker gets a single guess per exchange.
+type spake2 struct {
+	role spakeRole
+	w    *big.Int
+	x    *big.Int
+	msg  *big.Int
+}
+
+func newSpake2(role spakeRole, password string) (*spake2, error) {
+	x, err := rand.Int(rand.Reader, new(big.Int).Sub(groupQ, big.NewInt(1)))
+	if err != nil {
+		return nil, fmt.Errorf("generating scalar: %w", err)
+	}
+	x.Add(x, big.NewInt(1))
+
+	s := &spake2{
+		role: role,
+		w:    hashToScalar(password),
+		x:    x,
+	}
+
+	// msg = g^x * M^w for the sender, g^x * N^w for the receiver
+	s.msg = new(big.Int).Exp(groupG, s.x, groupP)
+	s.msg.Mul(s.msg, new(big.Int).Exp(s.blind(s.role), s.w, groupP))
+	s.msg.Mod(s.msg, groupP)
+
+	return s, nil
+}
+
+// message returns the encoded message to send to the peer.
+func (s *spake2) message() string {
+	return base64.StdEncoding.EncodeToString(elementBytes(s.msg))
+}
+
+// finish combines the peer's message with our own to derive a 32-byte shared key.
+//
+// Both sides only arrive at the same key if they used the same password and nameplate.
+func (s *spake2) finish(nameplate, peerMessage string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(peerMessage)
+	if err != nil || len(b) != elementSize {
+		return nil, errBadExchange
+	}
+	peer := new(big.Int).SetBytes(b)
+	if !inGroup(peer) {
+		return nil, errBadExchange
+	}
+
+	peerRole := spakeSender
+	if s.role == spakeSender {
+		peerRole = spakeReceiver
+	}
+
+	// K = (peer / blind^w)^x
+	unblind := new(big.Int).Exp(s.blind(peerRole), s.w, groupP)
+	unblind.ModInverse(unblind, groupP)
+	k := new(big.Int).Mul(peer, unblind)
+	k.Mod(k, groupP)
+	k.Exp(k, s.x, groupP)
+	if k.Cmp(big.NewInt(1)) == 0 {
+		return nil, errBadExchange
+	}
+
+	senderMsg, receiverMsg := s.msg, peer
+	if s.role == spakeReceiver {
+		senderMsg, receiverMsg = peer, s.msg
+	}
+
+	h := sha256.New()
+	writeField(h, []byte("storj relay spake2"))
+	writeField(h, []byte(nameplate))
+	writeField(h, elementBytes(senderMsg))
+	writeField(h, elementBytes(receiverMsg))
+	writeField(h, elementBytes(k))
+	writeField(h, s.w.Bytes())
+	return h.Sum(nil), nil
+}
+
+// keyConfirmation returns the receiver's proof to the sender that it derived key,
+// without revealing anything about the key itself.
+func keyConfirmation(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("storj relay receiver confirmation"))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *spake2) blind(role spakeRole) *big.Int {
+	if role == spakeSender {
+		return groupM
+	}
+	return groupN
+}
+
+// inGroup reports whether e is a member of the prime-order subgroup, excluding the identity.
+func inGroup(e *big.Int) bool {
+	if e.Cmp(big.NewInt(1)) <= 0 || e.Cmp(groupP) >= 0 {
+		return false
+	}
+	return new(big.Int).Exp(e, groupQ, groupP).Cmp(big.NewInt(1)) == 0
+}
+
+// hashToGroup deterministically maps a seed to a subgroup element with no known discrete log.
+func hashToGroup(seed string) *big.Int {
+	e := expandHash(seed, elementSize+8)
+	e.Mod(e, groupP)
+	return e.Exp(e, big.NewInt(2), groupP)
+}
+
+func hashToScalar(password string) *big.Int {
+	w := expandHash("storj relay spake2 password\x00"+password, elementSize+8)
+	return w.Mod(w, groupQ)
+}
+
+// expandHash stretches SHA-256 over a counter to produce an integer of n bytes.
+func expandHash(seed string, n int) *big.Int {
+	out := make([]byte, 0, n+sha256.Size)
+	for i := uint32(0); len(out) < n; i++ {
+		var ctr [4]byte
+		binary.BigEndian.PutUint32(ctr[:], i)
+		h := sha256.New()
+		h.Write(ctr[:])
+		h.Write([]byte(seed))
+		out = h.Sum(out)
+	}
+	return new(big.Int).SetBytes(out[:n])
+}
+
+// elementBytes encodes a group element as a fixed-width big-endian integer.
+func elementBytes(e *big.Int) []byte {
+	b := e.Bytes()
+	padded := make([]byte, elementSize)
+	copy(padded[elementSize-len(b):], b)
+	return padded
+}
+
+func writeField(h hash.Hash, b []byte) {
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(len(b)))
+	h.Write(size[:])
+	h.Write(b)
+}
+
+func mustParseHex(s string) *big.Int {
+	n, ok := new(big.Int).SetString(strings.Join(strings.Fields(s), ""), 16)
+	if !ok {
+		panic("relay: invalid hex constant")
+	}
+	return n
+}
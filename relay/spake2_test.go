@@ -0,0 +1,71 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"math/big"
+	"testing"
+)
+
+func TestSpake2Group(t *testing.T) {
+	if !groupP.ProbablyPrime(20) || !groupQ.ProbablyPrime(20) {
+		t.Error("group modulus is not a safe prime")
+	}
+
+	for name, e := range map[string]*big.Int{"G": groupG, "M": groupM, "N": groupN} {
+		if !inGroup(e) {
+			t.Errorf("%v is not in the group", name)
+		}
+	}
+}
+
+func TestSpake2Exchange(t *testing.T) {
+	const nameplate = "some-secret-string"
+
+	exchange := func(senderPassword, receiverPassword string) (senderKey, receiverKey []byte) {
+		sender, _ := newSpake2(spakeSender, senderPassword)
+		receiver, _ := newSpake2(spakeReceiver, receiverPassword)
+
+		senderKey, err := sender.finish(nameplate, receiver.message())
+		if err != nil {
+			t.Fatal(err)
+		}
+		receiverKey, err = receiver.finish(nameplate, sender.message())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return senderKey, receiverKey
+	}
+
+	t.Run("matching passwords agree on a key", func(t *testing.T) {
+		a, b := exchange("little-earth", "little-earth")
+
+		if !bytes.Equal(a, b) {
+			t.Errorf("got %x and %x, want equal keys", a, b)
+		}
+	})
+
+	t.Run("mismatched passwords disagree", func(t *testing.T) {
+		a, b := exchange("little-earth", "little-music")
+
+		if bytes.Equal(a, b) {
+			t.Errorf("got equal keys %x", a)
+		}
+	})
+
+	t.Run("rejects invalid messages", func(t *testing.T) {
+		s, _ := newSpake2(spakeSender, "little-earth")
+		invalid := []string{
+			"",
+			"not base64",
+			base64.StdEncoding.EncodeToString(elementBytes(big.NewInt(1))),
+			base64.StdEncoding.EncodeToString(elementBytes(new(big.Int).Sub(groupP, big.NewInt(1)))),
+		}
+
+		for _, msg := range invalid {
+			if _, err := s.finish(nameplate, msg); err != errBadExchange {
+				t.Errorf("got %v, want %v", err, errBadExchange)
+			}
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package relay
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// StallLimits abort transfers that stop making progress, so that a sender or receiver that has gone quiet
+// doesn't hold the other side's connection open indefinitely.
+type StallLimits struct {
+	// Idle is how long a transfer may go without relaying a single byte. Zero disables the limit.
+	Idle time.Duration
+
+	// MinRate is the slowest a transfer may go, in bytes per second, averaged over each Window.
+	// Zero disables the limit.
+	MinRate int64
+	Window  time.Duration
+}
+
+// DefaultStallLimits are enforced by a Handler unless it's created WithStallLimits.
+var DefaultStallLimits = StallLimits{
+	Idle:   time.Minute,
+	Window: 30 * time.Second,
+}
+
+// watch samples the bytes a transfer has moved until done is closed,
+// returning a channel that receives the reason if the transfer stalls.
+func (l StallLimits) watch(moved *int64, done <-chan struct{}) <-chan string {
+	stalled := make(chan string, 1)
+	window := l.Window
+	if l.MinRate <= 0 || window <= 0 {
+		window = 0
+	}
+
+	// sample often enough to notice a stall soon after a limit is crossed
+	period := l.Idle
+	if period <= 0 || (window > 0 && window < period) {
+		period = window
+	}
+	if period <= 0 {
+		return stalled
+	}
+	period /= 10
+
+	go func() {
+		tick := time.NewTicker(period)
+		defer tick.Stop()
+
+		last, lastMoved := atomic.LoadInt64(moved), time.Now()
+		windowBytes, windowStart := last, lastMoved
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-tick.C:
+				n := atomic.LoadInt64(moved)
+				if n != last {
+					last, lastMoved = n, now
+				}
+				if l.Idle > 0 && now.Sub(lastMoved) >= l.Idle {
+					stalled <- "idle"
+					return
+				}
+				if elapsed := now.Sub(windowStart); window > 0 && elapsed >= window {
+					if float64(n-windowBytes) < float64(l.MinRate)*elapsed.Seconds() {
+						stalled <- "too slow"
+						return
+					}
+					windowBytes, windowStart = n, now
+				}
+			}
+		}
+	}()
+	return stalled
+}
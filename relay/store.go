@@ -0,0 +1,211 @@
+package relay
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSecretTaken is returned by an OfferStore when reserving a secret that's already in use.
+var ErrSecretTaken = errors.New("secret already taken")
+
+// ErrNoOffer is returned by an OfferStore when looking up a secret that isn't in use.
+var ErrNoOffer = errors.New("no such offer")
+
+// OfferStore records which relay node holds each offer.
+//
+// Relay nodes that share a store, for instance behind a load balancer, never hand out the same secret,
+// and forward requests for offers they don't hold to the node that does.
+// A node is identified by the URL at which the others can reach it.
+type OfferStore interface {
+	// Reserve records that node holds the offer with secret,
+	// or returns ErrSecretTaken if any node already does.
+	Reserve(secret, node string) error
+	// Lookup returns the node holding the offer with secret, or ErrNoOffer.
+	Lookup(secret string) (node string, err error)
+	// Release forgets the offer with secret.
+	Release(secret string) error
+}
+
+// MemoryStore is an OfferStore for a single process.
+// It's used by a Handler unless it's created WithCluster.
+type MemoryStore struct {
+	sync.Mutex
+	nodes map[string]string
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{nodes: make(map[string]string)}
+}
+
+// Reserve implements OfferStore.
+func (s *MemoryStore) Reserve(secret, node string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.nodes[secret]; ok {
+		return ErrSecretTaken
+	}
+	s.nodes[secret] = node
+	return nil
+}
+
+// Lookup implements OfferStore.
+func (s *MemoryStore) Lookup(secret string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	node, ok := s.nodes[secret]
+	if !ok {
+		return "", ErrNoOffer
+	}
+	return node, nil
+}
+
+// Release implements OfferStore.
+func (s *MemoryStore) Release(secret string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.nodes, secret)
+	return nil
+}
+
+// FileStore is an OfferStore kept as a file per offer in a directory,
+// which nodes on different hosts can share over a network filesystem.
+//
+// Files are named by a hash of their secret, so the directory listing doesn't reveal any codes.
+// A node that crashes leaves its records behind, each holding a nameplate that every node shares,
+// so records older than the longest an offer can last are treated as gone and swept away.
+type FileStore struct {
+	dir    string
+	maxAge time.Duration
+
+	sync.Mutex
+	swept time.Time
+}
+
+// FileStoreOption configures a store created by NewFileStore.
+type FileStoreOption func(*FileStore)
+
+// WithMaxOfferTimeout expires records once they're older than the longest offers may wait,
+// rather than an hour, plus the time a transfer is given to resume.
+// It should match the maximum given to the Handlers sharing the store.
+func WithMaxOfferTimeout(d time.Duration) FileStoreOption {
+	return func(s *FileStore) {
+		s.maxAge = d + resumeTimeout
+	}
+}
+
+// NewFileStore returns a FileStore in dir, creating the directory if necessary.
+func NewFileStore(dir string, opts ...FileStoreOption) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating offer store: %w", err)
+	}
+	s := &FileStore{dir: dir, maxAge: maxOfferTimeout + resumeTimeout}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s, nil
+}
+
+// Reserve implements OfferStore.
+//
+// It writes the record to a temporary file and then links it into place,
+// which atomically fails if another node got there first.
+func (s *FileStore) Reserve(secret, node string) error {
+	s.sweep()
+
+	tmp, err := ioutil.TempFile(s.dir, ".reserve-")
+	if err != nil {
+		return fmt.Errorf("reserving offer: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(node); err != nil {
+		tmp.Close()
+		return fmt.Errorf("reserving offer: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("reserving offer: %w", err)
+	}
+
+	err = os.Link(tmp.Name(), s.path(secret))
+	if os.IsExist(err) && s.expire(s.path(secret)) {
+		err = os.Link(tmp.Name(), s.path(secret))
+	}
+	if os.IsExist(err) {
+		return ErrSecretTaken
+	}
+	if err != nil {
+		return fmt.Errorf("reserving offer: %w", err)
+	}
+	return nil
+}
+
+// Lookup implements OfferStore.
+func (s *FileStore) Lookup(secret string) (string, error) {
+	if s.expire(s.path(secret)) {
+		return "", ErrNoOffer
+	}
+	b, err := ioutil.ReadFile(s.path(secret))
+	if os.IsNotExist(err) {
+		return "", ErrNoOffer
+	}
+	if err != nil {
+		return "", fmt.Errorf("looking up offer: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// Release implements OfferStore.
+func (s *FileStore) Release(secret string) error {
+	if err := os.Remove(s.path(secret)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("releasing offer: %w", err)
+	}
+	return nil
+}
+
+// expire removes the file at path if it's too old to belong to a live offer, reporting whether it did.
+func (s *FileStore) expire(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) <= s.maxAge {
+		return false
+	}
+	return os.Remove(path) == nil
+}
+
+// sweep expires the records, and temporary files, of every node that crashed.
+// It lists the directory at most once a minute, since another node may have swept it anyway.
+func (s *FileStore) sweep() {
+	s.Lock()
+	if time.Since(s.swept) < time.Minute {
+		s.Unlock()
+		return
+	}
+	s.swept = time.Now()
+	s.Unlock()
+
+	f, err := os.Open(s.dir)
+	if err != nil {
+		return
+	}
+	names, _ := f.Readdirnames(-1)
+	f.Close()
+	for _, name := range names {
+		s.expire(filepath.Join(s.dir, name))
+	}
+}
+
+func (s *FileStore) path(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,112 @@
+package relay
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestOfferStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storj-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileStore, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stores := map[string]OfferStore{
+		"memory": NewMemoryStore(),
+		"file":   fileStore,
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			if err := store.Reserve("a-b-c", "http://node-1"); err != nil {
+				t.Fatal("reserving:", err)
+			}
+
+			t.Run("looks up the node", func(t *testing.T) {
+				got, err := store.Lookup("a-b-c")
+				want := "http://node-1"
+
+				if err != nil || got != want {
+					t.Errorf("got %q, %v, want %q", got, err, want)
+				}
+			})
+
+			t.Run("refuses a taken secret", func(t *testing.T) {
+				if err := store.Reserve("a-b-c", "http://node-2"); err != ErrSecretTaken {
+					t.Errorf("got %v, want %v", err, ErrSecretTaken)
+				}
+			})
+
+			t.Run("releases the secret", func(t *testing.T) {
+				if err := store.Release("a-b-c"); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := store.Lookup("a-b-c"); err != ErrNoOffer {
+					t.Errorf("got %v, want %v", err, ErrNoOffer)
+				}
+				if err := store.Reserve("a-b-c", "http://node-2"); err != nil {
+					t.Errorf("reserving again: %v", err)
+				}
+			})
+		})
+	}
+}
+
+func TestFileStoreExpiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storj-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStore(dir, WithMaxOfferTimeout(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"a-b-c", "d-e-f"} {
+		if err := store.Reserve(secret, "http://crashed"); err != nil {
+			t.Fatal("reserving:", err)
+		}
+	}
+
+	// the node that reserved them crashed long ago
+	old := time.Now().Add(-2 * time.Hour)
+	for _, secret := range []string{"a-b-c", "d-e-f"} {
+		if err := os.Chtimes(store.path(secret), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("reclaims a stale record", func(t *testing.T) {
+		if err := store.Reserve("a-b-c", "http://node-2"); err != nil {
+			t.Fatalf("got %v", err)
+		}
+		got, err := store.Lookup("a-b-c")
+		if want := "http://node-2"; err != nil || got != want {
+			t.Errorf("got %q, %v, want %q", got, err, want)
+		}
+	})
+
+	t.Run("doesn't look up a stale record", func(t *testing.T) {
+		if _, err := store.Lookup("d-e-f"); err != ErrNoOffer {
+			t.Errorf("got %v, want %v", err, ErrNoOffer)
+		}
+		if _, err := os.Stat(store.path("d-e-f")); !os.IsNotExist(err) {
+			t.Errorf("stale record remains: %v", err)
+		}
+	})
+
+	t.Run("keeps a live record", func(t *testing.T) {
+		if err := store.Reserve("a-b-c", "http://node-3"); err != ErrSecretTaken {
+			t.Errorf("got %v, want %v", err, ErrSecretTaken)
+		}
+	})
+}
@@ -0,0 +1,275 @@
+package relay
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+const (
+	chunkSize       = 64 * 1024
+	frameHeaderSize = 4
+	finalFrameFlag  = 1 << 31
+	saltSize        = 16
+)
+
+// ErrWrongSecret is returned when a received stream can't be decrypted with the given secret.
+var ErrWrongSecret = errors.New("wrong secret")
+
+// ErrDigestMismatch is returned at the end of a received stream whose SHA-256 differs from the sender's.
+var ErrDigestMismatch = errors.New("content digest mismatch")
+
+// Digester is implemented by received streams, which verify an end-to-end SHA-256 of the file.
+type Digester interface {
+	// Digest returns the SHA-256 of the file once it has been completely read and verified, or nil.
+	Digest() []byte
+}
+
+var errCorrupt = errors.New("stream failed authentication")
+
+// digest hashes each chunk of a stream exactly once, even if chunks are resent to resume a transfer.
+type digest struct {
+	hash hash.Hash
+	next uint64
+}
+
+func newDigest() *digest {
+	return &digest{hash: sha256.New()}
+}
+
+func (d *digest) write(seq uint64, chunk []byte) {
+	if seq == d.next {
+		d.hash.Write(chunk)
+		d.next++
+	}
+}
+
+func (d *digest) sum() []byte {
+	return d.hash.Sum(nil)
+}
+
+// sealer encrypts a plaintext stream into a sequence of authenticated frames.
+//
+// The stream starts with a random salt, which derives a fresh key from the shared one
+// so that resending a chunk never reuses a nonce under the same key.
+// Each frame is a 4-byte big-endian length, with the high bit marking the final frame,
+// followed by an AES-GCM sealed chunk of at most chunkSize bytes. The frame's sequence
+// number and final flag are bound into its nonce, so frames can't be reordered, dropped
+// or truncated without detection.
+// The final chunk is followed by the SHA-256 of the whole file.
+type sealer struct {
+	src    io.Reader
+	aead   cipher.AEAD
+	digest *digest
+	seq    uint64
+	plain  []byte
+	buf    []byte
+	frame  []byte
+	done   bool
+}
+
+// newSealer returns a reader of the encrypted stream of src,
+// numbering frames from seq to resume a stream from chunk number seq.
+// The digest is shared between every sealer of the same file.
+func newSealer(src io.Reader, key []byte, seq uint64, d *digest) (*sealer, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("generating salt: %w", err)
+	}
+	aead, err := newAEAD(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	return &sealer{
+		src:    src,
+		aead:   aead,
+		digest: d,
+		seq:    seq,
+		plain:  make([]byte, chunkSize, chunkSize+sha256.Size),
+		buf:    make([]byte, frameHeaderSize+maxFrameSize(aead)),
+		frame:  salt,
+	}, nil
+}
+
+func (s *sealer) Read(p []byte) (int, error) {
+	for len(s.frame) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.seal(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.frame)
+	s.frame = s.frame[n:]
+	return n, nil
+}
+
+func (s *sealer) seal() error {
+	n, err := io.ReadFull(s.src, s.plain)
+	final := false
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		final = true
+	default:
+		return err
+	}
+
+	plain := s.plain[:n]
+	s.digest.write(s.seq, plain)
+	if final {
+		plain = append(plain, s.digest.sum()...)
+	}
+
+	sealed := s.aead.Seal(s.buf[frameHeaderSize:frameHeaderSize], frameNonce(s.seq, final), plain, nil)
+	header := uint32(len(sealed))
+	if final {
+		header |= finalFrameFlag
+	}
+	binary.BigEndian.PutUint32(s.buf, header)
+
+	s.frame = s.buf[:frameHeaderSize+len(sealed)]
+	s.seq++
+	s.done = final
+	return nil
+}
+
+// opener decrypts and authenticates a stream of frames produced by a sealer.
+type opener struct {
+	src    io.ReadCloser
+	aead   cipher.AEAD
+	seq    uint64
+	buf    []byte
+	plain  []byte
+	digest []byte
+	done   bool
+}
+
+// newOpener returns a reader of the plaintext within src, which starts at chunk number seq.
+//
+// It reads and authenticates the first frame before returning,
+// so a mismatched key is reported immediately as ErrWrongSecret.
+func newOpener(src io.ReadCloser, key []byte, seq uint64) (*opener, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(src, salt); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	aead, err := newAEAD(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	o := &opener{
+		src:  src,
+		aead: aead,
+		seq:  seq,
+		buf:  make([]byte, frameHeaderSize+maxFrameSize(aead)),
+	}
+	if err := o.open(); err != nil {
+		if err == errCorrupt && seq == 0 {
+			return nil, ErrWrongSecret
+		}
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *opener) Read(p []byte) (int, error) {
+	for len(o.plain) == 0 {
+		if o.done {
+			return 0, io.EOF
+		}
+		if err := o.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, o.plain)
+	o.plain = o.plain[n:]
+	return n, nil
+}
+
+func (o *opener) Close() error {
+	return o.src.Close()
+}
+
+func (o *opener) open() error {
+	header := o.buf[:frameHeaderSize]
+	if _, err := io.ReadFull(o.src, header); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF // the stream ended before its final frame
+		}
+		return err
+	}
+
+	h := binary.BigEndian.Uint32(header)
+	final := h&finalFrameFlag != 0
+	size := int(h &^ finalFrameFlag)
+	if size > maxFrameSize(o.aead) {
+		return errCorrupt
+	}
+
+	sealed := o.buf[frameHeaderSize : frameHeaderSize+size]
+	if _, err := io.ReadFull(o.src, sealed); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	plain, err := o.aead.Open(sealed[:0], frameNonce(o.seq, final), sealed, nil)
+	if err != nil {
+		return errCorrupt
+	}
+	if final {
+		if len(plain) < sha256.Size {
+			return errCorrupt
+		}
+		split := len(plain) - sha256.Size
+		plain, o.digest = plain[:split], append([]byte(nil), plain[split:]...)
+	}
+
+	o.plain = plain
+	o.seq++
+	o.done = final
+	return nil
+}
+
+// sealedSize returns the length of the stream that seals a file of size bytes, starting from offset.
+func sealedSize(size, offset int64) int64 {
+	const overhead = 16 // AES-GCM's tag
+	frames := size/chunkSize + 1 - offset/chunkSize
+	return saltSize + frames*(frameHeaderSize+overhead) + (size - offset) + sha256.Size
+}
+
+func maxFrameSize(aead cipher.AEAD) int {
+	return chunkSize + sha256.Size + aead.Overhead()
+}
+
+func newAEAD(key, salt []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(salt)
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, fmt.Errorf("creating cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func frameNonce(seq uint64, final bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], seq)
+	if final {
+		nonce[11] = 1
+	}
+	return nonce
+}
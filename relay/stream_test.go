@@ -0,0 +1,152 @@
+package relay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func sealAll(t *testing.T, plain []byte, key []byte, seq uint64) []byte {
+	s, err := newSealer(bytes.NewReader(plain), key, seq, newDigest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := ioutil.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	sizes := []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 17}
+
+	for _, size := range sizes {
+		plain := bytes.Repeat([]byte{'X'}, size)
+		sealed := sealAll(t, plain, key, 0)
+
+		o, err := newOpener(ioutil.NopCloser(bytes.NewReader(sealed)), key, 0)
+		if err != nil {
+			t.Fatalf("opening %v bytes: %v", size, err)
+		}
+		got, err := ioutil.ReadAll(o)
+		if err != nil {
+			t.Fatalf("reading %v bytes: %v", size, err)
+		}
+
+		if !bytes.Equal(got, plain) {
+			t.Errorf("got %v bytes, want %v", len(got), size)
+		}
+
+		want := sha256.Sum256(plain)
+		if !bytes.Equal(o.digest, want[:]) {
+			t.Errorf("got digest %x, want %x", o.digest, want)
+		}
+	}
+}
+
+func TestStreamWrongKey(t *testing.T) {
+	sealed := sealAll(t, []byte("file contents"), bytes.Repeat([]byte{1}, 32), 0)
+
+	_, err := newOpener(ioutil.NopCloser(bytes.NewReader(sealed)), bytes.Repeat([]byte{2}, 32), 0)
+
+	if err != ErrWrongSecret {
+		t.Errorf("got %v, want %v", err, ErrWrongSecret)
+	}
+}
+
+func TestStreamResume(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	plain := bytes.Repeat([]byte{'X'}, chunkSize+1)
+
+	t.Run("fresh salts never repeat ciphertext", func(t *testing.T) {
+		a, b := sealAll(t, plain, key, 1), sealAll(t, plain, key, 1)
+
+		if bytes.Equal(a[saltSize:], b[saltSize:]) {
+			t.Error("got identical ciphertext for a resent chunk")
+		}
+	})
+
+	t.Run("opens from the resumed chunk", func(t *testing.T) {
+		sealed := sealAll(t, plain, key, 1)
+		o, err := newOpener(ioutil.NopCloser(bytes.NewReader(sealed)), key, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := ioutil.ReadAll(o)
+
+		if !bytes.Equal(got, plain) {
+			t.Errorf("got %v bytes, want %v", len(got), len(plain))
+		}
+	})
+
+	t.Run("rejects chunks out of position", func(t *testing.T) {
+		sealed := sealAll(t, plain, key, 1)
+		_, err := newOpener(ioutil.NopCloser(bytes.NewReader(sealed)), key, 2)
+
+		if err != errCorrupt {
+			t.Errorf("got %v, want %v", err, errCorrupt)
+		}
+	})
+}
+
+func TestStreamTampering(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	sealed := sealAll(t, bytes.Repeat([]byte{'X'}, 2*chunkSize), key, 0)
+	salt, frames := sealed[:saltSize], sealed[saltSize:]
+	frame := frameHeaderSize + chunkSize + 16
+
+	read := func(sealed []byte) error {
+		o, err := newOpener(ioutil.NopCloser(bytes.NewReader(sealed)), key, 0)
+		if err != nil {
+			return err
+		}
+		_, err = ioutil.ReadAll(o)
+		return err
+	}
+
+	t.Run("detects truncation", func(t *testing.T) {
+		if err := read(sealed[:saltSize+2*frame]); err != io.ErrUnexpectedEOF {
+			t.Errorf("got %v, want %v", err, io.ErrUnexpectedEOF)
+		}
+	})
+
+	t.Run("detects reordering", func(t *testing.T) {
+		swapped := append(append([]byte{}, salt...), frames[frame:2*frame]...)
+		swapped = append(swapped, frames[:frame]...)
+		swapped = append(swapped, frames[2*frame:]...)
+
+		if err := read(swapped); err == nil {
+			t.Error("got nil error, want failure")
+		}
+	})
+
+	t.Run("detects modification", func(t *testing.T) {
+		modified := append([]byte{}, sealed...)
+		modified[saltSize+frame+frameHeaderSize] ^= 1
+
+		if err := read(modified); err != errCorrupt {
+			t.Errorf("got %v, want %v", err, errCorrupt)
+		}
+	})
+}
+
+func TestStreamSealedSize(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	sizes := []int64{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3*chunkSize + 17}
+
+	for _, size := range sizes {
+		plain := bytes.Repeat([]byte{'X'}, int(size))
+		for offset := int64(0); offset <= size; offset += chunkSize {
+			got := int64(len(sealAll(t, plain[offset:], key, uint64(offset/chunkSize))))
+			want := sealedSize(size, offset)
+
+			if got != want {
+				t.Errorf("%v bytes from %v: sealed %v, want %v", size, offset, got, want)
+			}
+		}
+	}
+}
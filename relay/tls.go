@@ -0,0 +1,144 @@
+package relay
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CertificateFingerprint returns the hex-encoded SHA-256 of a DER-encoded certificate.
+func CertificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// PinnedTLSConfig returns a TLS configuration that trusts only the certificate with the given
+// SHA-256 fingerprint, such as one printed by a relay using a self-signed certificate.
+//
+// Colons in the fingerprint are ignored.
+func PinnedTLSConfig(fingerprint string) (*tls.Config, error) {
+	want, err := hex.DecodeString(strings.Replace(fingerprint, ":", "", -1))
+	if err != nil || len(want) != sha256.Size {
+		return nil, fmt.Errorf("invalid certificate fingerprint: %q", fingerprint)
+	}
+
+	return &tls.Config{
+		// the chain isn't verified against any CA, but the leaf must match the pin exactly
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("no certificate presented")
+			}
+			got := sha256.Sum256(rawCerts[0])
+			if !bytes.Equal(got[:], want) {
+				return fmt.Errorf("certificate fingerprint %x doesn't match pin", got)
+			}
+			return nil
+		},
+	}, nil
+}
+
+// CAFileTLSConfig returns a TLS configuration that trusts the PEM-encoded CA certificates in path,
+// rather than the system's roots.
+func CAFileTLSConfig(path string) (*tls.Config, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %v", path)
+	}
+	return &tls.Config{RootCAs: pool}, nil
+}
+
+// SelfSignedCertificate generates a certificate and key for the given hostnames and IP addresses,
+// valid for one year.
+//
+// Clients can't verify it against a CA, so share its fingerprint for them to pin instead.
+func SelfSignedCertificate(hosts ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("generating key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("generating serial number: %w", err)
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "storj relay"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if h != "" {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("creating certificate: %w", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// PersistentSelfSignedCertificate is SelfSignedCertificate, but keeps the certificate and key as
+// cert.pem and key.pem in dir, and loads them from there on later calls, so that the fingerprint
+// clients have pinned survives restarts. A new certificate replaces one that's expired.
+func PersistentSelfSignedCertificate(dir string, hosts ...string) (tls.Certificate, error) {
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err == nil && time.Now().Before(leaf.NotAfter) {
+			return cert, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return tls.Certificate{}, fmt.Errorf("loading certificate: %w", err)
+	}
+
+	cert, err = SelfSignedCertificate(hosts...)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("encoding key: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return tls.Certificate{}, fmt.Errorf("saving certificate: %w", err)
+	}
+	// the key first, so that a certificate is never saved without it
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600); err != nil {
+		return tls.Certificate{}, fmt.Errorf("saving key: %w", err)
+	}
+	if err := ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0644); err != nil {
+		return tls.Certificate{}, fmt.Errorf("saving certificate: %w", err)
+	}
+	return cert, nil
+}
@@ -0,0 +1,152 @@
+package relay
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func transfer(sender, receiver *Client, contents string) (string, error) {
+	file := ioutil.NopCloser(strings.NewReader(contents))
+	secret, send, err := sender.Offer("file.txt", file)
+	if err != nil {
+		return "", err
+	}
+	go send(context.Background())
+
+	_, stream, err := receiver.Receive(secret)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+
+	received, err := ioutil.ReadAll(stream)
+	return string(received), err
+}
+
+func TestClientTLS(t *testing.T) {
+	const contents = "file contents"
+
+	server := httptest.NewTLSServer(NewHandler(newSecretList("some-secret-string"), ioutil.Discard))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+
+	t.Run("trusts a custom CA", func(t *testing.T) {
+		pool := x509.NewCertPool()
+		pool.AddCert(server.Certificate())
+		config := &tls.Config{RootCAs: pool}
+
+		got, err := transfer(NewClient(u.Host, WithTLSConfig(config)), NewClient(server.URL, WithTLSConfig(config)), contents)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != contents {
+			t.Errorf("got %q, want %q", got, contents)
+		}
+	})
+
+	t.Run("trusts a pinned certificate", func(t *testing.T) {
+		config, err := PinnedTLSConfig(CertificateFingerprint(server.Certificate().Raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := transfer(NewClient(u.Host, WithTLSConfig(config)), NewClient(u.Host, WithTLSConfig(config)), contents)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != contents {
+			t.Errorf("got %q, want %q", got, contents)
+		}
+	})
+
+	t.Run("rejects a mismatched pin", func(t *testing.T) {
+		config, _ := PinnedTLSConfig(strings.Repeat("ab", 32))
+		client := NewClient(u.Host, WithTLSConfig(config))
+
+		if _, _, err := client.Offer("file.txt", ioutil.NopCloser(strings.NewReader(contents))); err == nil {
+			t.Error("got nil error, want certificate failure")
+		}
+	})
+
+	t.Run("rejects an untrusted certificate", func(t *testing.T) {
+		client := NewClient(server.URL)
+
+		if _, _, err := client.Offer("file.txt", ioutil.NopCloser(strings.NewReader(contents))); err == nil {
+			t.Error("got nil error, want certificate failure")
+		}
+	})
+}
+
+func TestSelfSignedCertificate(t *testing.T) {
+	cert, err := SelfSignedCertificate("127.0.0.1", "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(NewHandler(newSecretList("some-secret-string"), ioutil.Discard))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	defer server.Close()
+
+	leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+
+	t.Run("verifies for its hosts", func(t *testing.T) {
+		pool := x509.NewCertPool()
+		pool.AddCert(leaf)
+		if _, err := leaf.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: pool}); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("serves with its fingerprint pinned", func(t *testing.T) {
+		config, _ := PinnedTLSConfig(CertificateFingerprint(cert.Certificate[0]))
+		client := NewClient(server.URL, WithTLSConfig(config))
+
+		if _, _, err := client.Offer("file.txt", ioutil.NopCloser(strings.NewReader("file contents"))); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestPersistentSelfSignedCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storj-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir = filepath.Join(dir, "relay") // created if missing
+
+	first, err := PersistentSelfSignedCertificate(dir, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := PersistentSelfSignedCertificate(dir, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("reuses the certificate", func(t *testing.T) {
+		if got, want := CertificateFingerprint(second.Certificate[0]), CertificateFingerprint(first.Certificate[0]); got != want {
+			t.Errorf("got fingerprint %v, want %v", got, want)
+		}
+	})
+
+	t.Run("keeps the key private", func(t *testing.T) {
+		info, err := os.Stat(filepath.Join(dir, "key.pem"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := info.Mode().Perm(), os.FileMode(0600); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package relay
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// wordlists are the lists of words built into the relay, by ISO 639-1 language code.
+var wordlists = map[string][]string{
+	"de": germanWords,
+	"en": words,
+	"es": spanishWords,
+	"fr": frenchWords,
+}
+
+// Languages returns the codes of the languages that have a built-in wordlist, in alphabetical order.
+func Languages() []string {
+	langs := make([]string, 0, len(wordlists))
+	for lang := range wordlists {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Wordlist returns the built-in wordlist for a language, by its ISO 639-1 code (eg, "es"),
+// for use with WithWordlist. It reports false if there's no list for the language.
+func Wordlist(lang string) ([]string, bool) {
+	list, ok := wordlists[strings.ToLower(lang)]
+	if !ok {
+		return nil, false
+	}
+	return append([]string(nil), list...), true
+}
+
+// ValidateWordlist checks that a wordlist is fit for secrets that people read aloud and type.
+// It rejects empty lists and lists with duplicate words, words that contain the "-" separator or whitespace,
+// or more than one word from any group of homophones: words that sound alike, like "there" and "their".
+// Which words sound alike depends on the language and accent of the people using the relay,
+// so the groups are configured by the caller, for instance with ReadHomophones.
+func ValidateWordlist(list []string, homophones [][]string) error {
+	if len(list) == 0 {
+		return fmt.Errorf("wordlist is empty")
+	}
+	seen := make(map[string]bool, len(list))
+	for _, word := range list {
+		switch {
+		case word == "":
+			return fmt.Errorf("wordlist contains an empty word")
+		case strings.ContainsAny(word, "- \t"):
+			return fmt.Errorf("word %q contains a separator", word)
+		case seen[word]:
+			return fmt.Errorf("word %q appears more than once", word)
+		}
+		seen[word] = true
+	}
+	for _, group := range homophones {
+		var found []string
+		for _, word := range group {
+			if seen[word] {
+				found = append(found, word)
+			}
+		}
+		if len(found) > 1 {
+			return fmt.Errorf("words %q sound alike", found)
+		}
+	}
+	return nil
+}
+
+// ReadHomophones reads groups of words that sound alike for ValidateWordlist, one group per line,
+// with words separated by spaces or commas. Blank lines and lines beginning with "#" are skipped,
+// and words are lowercased.
+func ReadHomophones(r io.Reader) ([][]string, error) {
+	var groups [][]string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		group := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		groups = append(groups, group)
+	}
+	return groups, scanner.Err()
+}
@@ -0,0 +1,75 @@
+package relay
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinWordlists(t *testing.T) {
+	if got, want := Languages(), []string{"de", "en", "es", "fr"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	for _, lang := range Languages() {
+		t.Run(lang, func(t *testing.T) {
+			list, ok := Wordlist(lang)
+			if !ok {
+				t.Fatal("no wordlist")
+			}
+			if err := ValidateWordlist(list, nil); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	if _, ok := Wordlist("xx"); ok {
+		t.Error("got a wordlist for an unknown language")
+	}
+	list, _ := Wordlist("en")
+	list[0] = "changed"
+	if words[0] == "changed" {
+		t.Error("changing a returned wordlist changed the built-in list")
+	}
+}
+
+func TestValidateWordlist(t *testing.T) {
+	homophones := [][]string{{"there", "their", "they're"}, {"write", "right"}}
+	tests := []struct {
+		name string
+		list []string
+		err  string
+	}{
+		{"valid", []string{"there", "write", "apple"}, ""},
+		{"empty", nil, "wordlist is empty"},
+		{"empty word", []string{"apple", ""}, "wordlist contains an empty word"},
+		{"separator", []string{"apple", "ice-cream"}, `word "ice-cream" contains a separator`},
+		{"space", []string{"apple", "ice cream"}, `word "ice cream" contains a separator`},
+		{"duplicate", []string{"apple", "pear", "apple"}, `word "apple" appears more than once`},
+		{"homophones", []string{"their", "apple", "there"}, `words ["there" "their"] sound alike`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateWordlist(test.list, homophones)
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("got %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.err {
+				t.Errorf("got %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestReadHomophones(t *testing.T) {
+	groups, err := ReadHomophones(strings.NewReader("# sound alike\nThere, their they're\n\n  write,right \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"there", "their", "they're"}, {"write", "right"}}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("got %q, want %q", groups, want)
+	}
+}
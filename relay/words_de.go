@@ -0,0 +1,249 @@
+package relay
+
+import "strings"
+
+// germanWords is a list of common German words, without accents so that they're easy to type.
+var germanWords = strings.Split(`adler
+affe
+ameise
+anker
+apfel
+arm
+ast
+auge
+auto
+bach
+ball
+ballon
+banane
+bank
+bart
+bauer
+baum
+becher
+berg
+besen
+bett
+biene
+bild
+birne
+blatt
+bleistift
+blitz
+blume
+bohne
+boot
+brief
+brille
+brot
+brunnen
+buch
+burg
+butter
+dach
+dorf
+dose
+drache
+eichel
+eimer
+eis
+ente
+erde
+esel
+eule
+fahne
+farbe
+fass
+feder
+feld
+fels
+fenster
+feuer
+fisch
+flasche
+fliege
+flugzeug
+fluss
+frosch
+gabel
+gans
+garten
+geige
+geld
+gitarre
+glas
+glocke
+gold
+gras
+gurke
+hafen
+hafer
+hahn
+hammer
+hand
+hase
+haus
+hecke
+heft
+helm
+hemd
+herz
+himmel
+hirsch
+holz
+honig
+hose
+huhn
+hummel
+hund
+hut
+igel
+insel
+jacke
+kaffee
+kamel
+kamm
+kanne
+karte
+kater
+katze
+keks
+kerze
+kette
+kind
+kirche
+kirsche
+kiste
+klee
+kleid
+knopf
+koch
+koffer
+korb
+kran
+krone
+krug
+kuchen
+kugel
+lampe
+laterne
+leiter
+licht
+lineal
+loch
+loewe
+luft
+mantel
+mauer
+maus
+meer
+messer
+milch
+moewe
+mond
+motor
+mund
+muschel
+nadel
+nagel
+nase
+nest
+netz
+nuss
+ofen
+ohr
+onkel
+paket
+palme
+pfanne
+pfeffer
+pferd
+pflaume
+pilz
+pinsel
+puppe
+quelle
+rabe
+rad
+rakete
+raupe
+regen
+reifen
+riese
+ring
+ritter
+rock
+rose
+sack
+salz
+sand
+sattel
+schaf
+schaufel
+schiff
+schirm
+schloss
+schnecke
+schnee
+schrank
+schuh
+schule
+schwamm
+schwan
+segel
+seil
+sessel
+socke
+sonne
+spiegel
+spinne
+stall
+stein
+stempel
+stern
+stift
+strand
+strauch
+stuhl
+sturm
+suppe
+tafel
+tal
+tanne
+tasche
+tasse
+teller
+teppich
+tiger
+tisch
+tomate
+topf
+traube
+trommel
+tulpe
+turm
+uhr
+vase
+vogel
+wagen
+wald
+wand
+wasser
+wiese
+wippe
+wolf
+wolke
+wolle
+wurm
+wurst
+zahn
+zange
+zaun
+zebra
+zelt
+ziege
+zitrone
+zucker
+zug
+zwerg
+zwiebel`, "\n")
@@ -0,0 +1,337 @@
+package relay
+
+import "strings"
+
+// spanishWords is a list of common Spanish words, without accents so that they're easy to type.
+var spanishWords = strings.Split(`abajo
+abeja
+abrazo
+abrigo
+abuelo
+agua
+aire
+ajo
+ala
+alma
+alto
+amarillo
+amigo
+ancho
+ancla
+anillo
+animal
+antes
+anzuelo
+ardilla
+arena
+arco
+arriba
+arroz
+asiento
+azul
+bailar
+ballena
+banco
+bandera
+barco
+barro
+bolsa
+bosque
+bota
+botella
+brazo
+brisa
+broma
+bueno
+burro
+caballo
+cabeza
+cabra
+cadena
+caja
+caldo
+calle
+calor
+cama
+camino
+campana
+campo
+canal
+cangrejo
+canoa
+cansado
+cara
+carne
+carro
+carta
+casa
+castillo
+cebolla
+cena
+cepillo
+cerdo
+cereza
+cesta
+cielo
+cine
+cinta
+circo
+ciudad
+clavel
+clavo
+cobija
+cobre
+coche
+cohete
+collar
+color
+cometa
+conejo
+copa
+corona
+correo
+cosa
+costa
+cuadro
+cuchara
+cuello
+cuerda
+cuerno
+cuento
+cueva
+dado
+dardo
+dedo
+diente
+dinero
+disco
+doctor
+domingo
+duende
+dulce
+durazno
+elefante
+enano
+escoba
+espada
+espejo
+estrella
+estufa
+falda
+faro
+fideo
+fiesta
+flecha
+flor
+foca
+fresa
+fruta
+fuego
+fuente
+gallina
+gancho
+garra
+gato
+gigante
+globo
+gorra
+gota
+grano
+grillo
+guante
+guitarra
+gusano
+harina
+helado
+hermano
+hielo
+hierba
+hierro
+higo
+hilo
+hoja
+hombre
+hongo
+hormiga
+horno
+hueso
+huevo
+humo
+idioma
+iglesia
+isla
+jarra
+jaula
+jirafa
+joven
+juego
+jugo
+juguete
+ladrillo
+lago
+lana
+lazo
+leche
+lechuga
+lengua
+libro
+limpio
+lindo
+llave
+lluvia
+lobo
+loco
+loro
+luna
+madera
+madre
+maleta
+mano
+manta
+manzana
+mapa
+mar
+marco
+marea
+mariposa
+martillo
+mejilla
+mesa
+miel
+mochila
+moneda
+mono
+monte
+morado
+mosca
+muela
+mundo
+muro
+naranja
+nariz
+negro
+nido
+nieve
+noche
+nube
+nudo
+nuevo
+ola
+olla
+oreja
+oro
+oso
+padre
+paja
+pala
+palabra
+palo
+paloma
+pan
+panda
+papel
+parque
+pasto
+pato
+payaso
+pecho
+peine
+pelota
+pera
+perla
+perro
+pescado
+pez
+piano
+piedra
+pierna
+pimienta
+pino
+pintura
+piso
+pista
+plata
+plato
+playa
+plaza
+pluma
+pollo
+polvo
+pozo
+puente
+puerta
+pulga
+pulpo
+queso
+radio
+rama
+rana
+rayo
+red
+regalo
+reloj
+remo
+rey
+risa
+roca
+rojo
+ropa
+rosa
+rueda
+saco
+sal
+salsa
+salto
+sapo
+selva
+semilla
+senda
+serpiente
+sierra
+silla
+sobre
+sol
+sombra
+sombrero
+sopa
+suelo
+taco
+tambor
+tapa
+taza
+techo
+teja
+tela
+tienda
+tierra
+tigre
+tijera
+tinta
+tiza
+toalla
+tomate
+toro
+torre
+tortuga
+trigo
+trompeta
+tren
+trueno
+tubo
+uva
+vaca
+valle
+vapor
+vaquero
+vaso
+vela
+ventana
+verde
+vestido
+viaje
+viento
+vino
+violeta
+zanahoria
+zapato
+zorro`, "\n")
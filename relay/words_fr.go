@@ -0,0 +1,262 @@
+package relay
+
+import "strings"
+
+// frenchWords is a list of common French words, without accents so that they're easy to type.
+var frenchWords = strings.Split(`abeille
+abricot
+acier
+aigle
+aile
+ami
+ananas
+ancre
+anneau
+arbre
+argent
+armoire
+avion
+avocat
+bague
+baguette
+baleine
+balle
+bambou
+banane
+barque
+bateau
+bijou
+biscuit
+blanc
+bleu
+bocal
+bois
+bonbon
+bonnet
+bottes
+bouche
+bougie
+bouquet
+bouteille
+bouton
+bras
+brique
+brosse
+bureau
+cactus
+cadeau
+cadenas
+cahier
+camion
+canard
+carafe
+caravane
+carotte
+carte
+casque
+castor
+cerise
+chaise
+chameau
+champ
+chapeau
+chat
+chaton
+chemin
+chemise
+cheval
+cheveu
+chien
+chocolat
+cigale
+citron
+cloche
+clou
+cochon
+cocotte
+coffre
+colline
+colombe
+concert
+coq
+corbeau
+corde
+coton
+couteau
+crabe
+crayon
+crocodile
+cygne
+dauphin
+dent
+dessin
+diamant
+dinosaure
+domino
+dragon
+drapeau
+escargot
+fauteuil
+ferme
+feuille
+fille
+flamme
+fleur
+fleuve
+fourchette
+fourmi
+fourneau
+fraise
+framboise
+fromage
+gant
+gazon
+genou
+girafe
+glace
+gorille
+grenouille
+griffe
+guitare
+hache
+hamac
+hibou
+hiver
+horloge
+huile
+igloo
+image
+jambe
+jardin
+jaune
+jongleur
+jouet
+journal
+jupe
+kiwi
+lacet
+lampe
+lanterne
+lapin
+lavande
+lettre
+lion
+livre
+loup
+luge
+lune
+lunettes
+maison
+mangue
+manteau
+marmotte
+marteau
+melon
+miel
+miroir
+montagne
+mouche
+mouette
+moulin
+mouton
+musique
+navire
+noisette
+nuage
+nuit
+oiseau
+olive
+orage
+orange
+orchestre
+oreille
+ours
+outil
+palmier
+panier
+pantalon
+papier
+papillon
+paquet
+parapluie
+parc
+pastel
+patin
+peigne
+pelle
+perle
+perroquet
+phare
+piano
+pieuvre
+pierre
+pigeon
+pinceau
+pingouin
+pirate
+placard
+plage
+plante
+plume
+poche
+poisson
+poivre
+pomme
+pont
+porte
+potiron
+poule
+poupon
+prune
+puits
+pupitre
+quille
+radeau
+radis
+raisin
+raquette
+renard
+requin
+rideau
+robe
+robot
+rocher
+rose
+roue
+ruban
+sable
+sac
+salade
+sandale
+sapin
+savon
+serpent
+sirop
+soleil
+sorcier
+souris
+stylo
+sucre
+table
+tambour
+tapis
+tasse
+taupe
+tigre
+toit
+tomate
+tonneau
+tortue
+tracteur
+train
+trompette
+trottinette
+tuba
+tulipe
+vache
+valise
+violette
+violon
+voiture
+wagon
+yaourt`, "\n")